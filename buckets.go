@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// bucketRoot is the prefix of every bucket path: UN_CONTACTS, or the BUCKET_STORE location.
+func bucketRoot() string { return bucketStore().Root() }
+
+// bucketNames lists the bucket directories under root (hidden ones such as .trash excluded).
+func bucketNames(root string) []string {
+	entries, err := storeReadDir(root)
+	if err != nil {
+		return nil
+	}
+	out := []string{}
+	for _, e := range entries {
+		if e.IsDir && !strings.HasPrefix(e.Name, ".") {
+			out = append(out, e.Name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// readVCF decodes every card in a VCF file without touching it, decrypting encrypted
+// bucket files with the bucket passphrase.
+func readVCF(path string) ([]vcard.Card, error) {
+	data, err := storeRead(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = openBucketData(data); err != nil {
+		return nil, err
+	}
+	if bytes.Contains(data, []byte("VERSION:2.1")) {
+		return decodeVCFText(data)
+	}
+	dec := vcard.NewDecoder(bytes.NewReader(data))
+	var cards []vcard.Card
+	for {
+		c, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cards, err
+		}
+		cards = append(cards, c)
+	}
+	return cards, nil
+}
+
+// writeVCF writes cards to path as-is (REV untouched), encrypted when a bucket passphrase
+// is set; no cards removes the file.
+func writeVCF(path string, cards []vcard.Card) error {
+	return writeVCFAs(path, cards, encryptBuckets())
+}
+
+func writeVCFAs(path string, cards []vcard.Card, encrypt bool) error {
+	if err := writeVCFData(path, cards, encrypt); err != nil {
+		return err
+	}
+	if idx := loadedBucketIndex(); idx != nil {
+		idx.update(path, cards)
+	}
+	return nil
+}
+
+func writeVCFData(path string, cards []vcard.Card, encrypt bool) error {
+	if len(cards) == 0 {
+		err := storeRemove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data := encodeCards(cards)
+	if encrypt {
+		var err error
+		if data, err = sealBucketData(data); err != nil {
+			return err
+		}
+	}
+	return storeWrite(path, data)
+}
+
+// bucketVCFs lists the .vcf files of one bucket directory.
+func bucketVCFs(dir string) []string {
+	out := []string{}
+	walkStore(dir, false, func(p string, _ storeEntry) {
+		if strings.ToLower(filepath.Ext(p)) == ".vcf" {
+			out = append(out, p)
+		}
+	})
+	sort.Strings(out)
+	return out
+}
+
+// runBuckets dispatches `dav contacts buckets <command>`.
+func runBuckets(args []string) {
+	if len(args) == 0 {
+		bucketsUsage()
+		return
+	}
+	switch args[0] {
+	case "mirror":
+		cmd := flag.NewFlagSet("buckets mirror", flag.ExitOnError)
+		apply := cmd.Bool("apply", false, "create collections and sync cards (default dry-run plan)")
+		only := cmd.String("bucket", "", "mirror only this bucket")
+		cmd.Parse(args[1:])
+		mirrorBuckets(*only, *apply)
+	case "search":
+		cmd := flag.NewFlagSet("buckets search", flag.ExitOnError)
+		var q bucketQuery
+		cmd.StringVar(&q.Phone, "phone", "", "number or 4+ digits of it (format-insensitive)")
+		cmd.StringVar(&q.Email, "email", "", "address or part of it")
+		cmd.StringVar(&q.Name, "name", "", "name words (prefixes and small typos match)")
+		cmd.StringVar(&q.Bucket, "bucket", "", "only this bucket")
+		cmd.Parse(args[1:])
+		if q.empty() {
+			log.Fatalf("buckets search: give at least one of --phone, --email, --name, --bucket")
+		}
+		searchBuckets(q)
+	case "reindex":
+		reindexBuckets()
+	case "stats":
+		bucketStats()
+	case "due":
+		cmd := flag.NewFlagSet("buckets due", flag.ExitOnError)
+		only := cmd.String("bucket", "", "only this bucket")
+		cmd.Parse(args[1:])
+		printDue(*only)
+	case "expire":
+		cmd := flag.NewFlagSet("buckets expire", flag.ExitOnError)
+		apply := cmd.Bool("apply", false, "move expired cards to .trash and purge old trash (default dry-run report)")
+		only := cmd.String("bucket", "", "only this bucket")
+		cmd.Parse(args[1:])
+		expireBuckets(*only, *apply)
+	case "encrypt", "decrypt":
+		cmd := flag.NewFlagSet("buckets "+args[0], flag.ExitOnError)
+		apply := cmd.Bool("apply", false, "rewrite the files (default: count what would change)")
+		cmd.Parse(args[1:])
+		migrateBucketCrypto(args[0] == "encrypt", *apply)
+	case "dedupe":
+		cmd := flag.NewFlagSet("buckets dedupe", flag.ExitOnError)
+		apply := cmd.Bool("apply", false, "merge duplicates and quarantine the extras (default dry-run report)")
+		cmd.Parse(args[1:])
+		dedupeBuckets(*apply)
+	default:
+		bucketsUsage()
+	}
+}
+
+func bucketsUsage() {
+	fmt.Println("Usage: dav contacts buckets <command> [options]")
+	fmt.Println("Commands:")
+	fmt.Println("  mirror   [--bucket NAME] [--apply]  # two-way sync each bucket with its own CardDAV collection")
+	fmt.Println("  search   [--phone N] [--email E] [--name N] [--bucket B]  # fuzzy lookup across all bucket VCFs")
+	fmt.Println("  reindex                             # rebuild the local bucket index (BUCKET_INDEX) from scratch")
+	fmt.Println("  stats                               # per-bucket counts, age distribution, entries missing phone/email")
+	fmt.Println("  due      [--bucket NAME]            # entries past their bucket's review_after/expire_after (rules file)")
+	fmt.Println("  expire   [--bucket NAME] [--apply]  # move expired entries to .trash; purge trash older than trash.retention")
+	fmt.Println("  dedupe   [--apply]                  # merge duplicate cards across buckets; extras go to .trash with a report")
+	fmt.Println("  encrypt  [--apply]                  # encrypt every bucket VCF with BUCKET_PASSPHRASE (AES-256-GCM, scrypt)")
+	fmt.Println("  decrypt  [--apply]                  # turn an encrypted tree back into plaintext VCFs")
+}
+
+func logBucketErr(op, path string, err error) {
+	if err != nil {
+		log.Printf("%s %s: %v", op, path, err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// cardCategories returns the card's CATEGORIES across all fields, trimmed and without
+// case-insensitive duplicates.
+func cardCategories(card vcard.Card) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, f := range card[vcard.FieldCategories] {
+		for _, c := range strings.Split(f.Value, ",") {
+			c = strings.TrimSpace(c)
+			if c == "" || seen[norm(c)] {
+				continue
+			}
+			seen[norm(c)] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// setCardCategories writes cats as a single CATEGORIES field, or removes it when empty.
+func setCardCategories(card *vcard.Card, cats []string) {
+	if len(cats) == 0 {
+		delete(*card, vcard.FieldCategories)
+		return
+	}
+	card.SetCategories(cats)
+}
+
+// hasCategory reports whether the card is tagged cat (case-insensitive).
+func hasCategory(card vcard.Card, cat string) bool {
+	for _, c := range cardCategories(card) {
+		if norm(c) == norm(cat) {
+			return true
+		}
+	}
+	return false
+}
+
+// addCategories tags the card; it reports whether anything was added.
+func addCategories(card *vcard.Card, cats ...string) bool {
+	cur := cardCategories(*card)
+	added := false
+	for _, c := range cats {
+		c = strings.TrimSpace(c)
+		if c == "" || containsFold(cur, c) {
+			continue
+		}
+		cur = append(cur, c)
+		added = true
+	}
+	if added {
+		setCardCategories(card, cur)
+	}
+	return added
+}
+
+// removeCategories untags the card; it reports whether anything was removed.
+func removeCategories(card *vcard.Card, cats ...string) bool {
+	kept := []string{}
+	for _, c := range cardCategories(*card) {
+		if !containsFold(cats, c) {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == len(cardCategories(*card)) {
+		return false
+	}
+	setCardCategories(card, kept)
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if norm(v) == norm(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterByCategory keeps the cards tagged cat.
+func filterByCategory(cards []cardData, cat string) []cardData {
+	out := []cardData{}
+	for _, cd := range cards {
+		if hasCategory(cd.Card, cat) {
+			out = append(out, cd)
+		}
+	}
+	return out
+}
+
+// runTag implements `tag add|remove|list` on server cards.
+func runTag(action, name string, tags []string) {
+	client := newClient()
+	ctx := context.Background()
+	cards := mustFetch(client)
+	if action == "list" {
+		if name != "" {
+			target := findByName(cards, name)
+			if target == nil {
+				log.Fatalf("tag: %s not found", name)
+			}
+			fmt.Println(strings.Join(cardCategories(target.Card), "\n"))
+			return
+		}
+		counts := map[string]int{}
+		label := map[string]string{}
+		for _, cd := range cards {
+			for _, c := range cardCategories(cd.Card) {
+				counts[norm(c)]++
+				if _, ok := label[norm(c)]; !ok {
+					label[norm(c)] = c
+				}
+			}
+		}
+		keys := []string{}
+		for k := range counts {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%5d  %s\n", counts[k], label[k])
+		}
+		return
+	}
+	if name == "" || len(tags) == 0 {
+		log.Fatalf("tag %s: --name and --tags are required", action)
+	}
+	target := findByName(cards, name)
+	if target == nil {
+		log.Fatalf("tag: %s not found", name)
+	}
+	var changed bool
+	switch action {
+	case "add":
+		changed = addCategories(&target.Card, tags...)
+	case "remove":
+		changed = removeCategories(&target.Card, tags...)
+	default:
+		log.Fatalf("tag: unknown action %q (use add|remove|list)", action)
+	}
+	if !changed {
+		log.Printf("tag: %s unchanged (%s)", name, strings.Join(cardCategories(target.Card), ", "))
+		return
+	}
+	setRevNow(&target.Card)
+	if err := client.put(ctx, target.Ref, target.Card); err != nil {
+		log.Fatalf("tag: %v", err)
+	}
+	log.Printf("tagged %s: %s", name, strings.Join(cardCategories(target.Card), ", "))
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mime/quotedprintable"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// vcardVersions are the versions convertCard writes. CardDAV (RFC 6352) stores 3.0 and
+// 4.0 only; 2.1 is for files handed to old phones.
+var vcardVersions = []string{"2.1", "3.0", "4.0"}
+
+// versionRenames are properties 4.0 added that 3.0 clients (Apple, DAVx5) carry as X-
+// properties.
+var versionRenames = []struct{ v3, v4 string }{
+	{"X-ADDRESSBOOKSERVER-KIND", vcard.FieldKind},
+	{"X-ADDRESSBOOKSERVER-MEMBER", vcard.FieldMember},
+	{"X-ANNIVERSARY", vcard.FieldAnniversary},
+	{"X-GENDER", vcard.FieldGender},
+}
+
+// mediaFields hold inline binary data: ENCODING=b in 2.1/3.0, data: URIs in 4.0.
+var mediaFields = []string{vcard.FieldPhoto, vcard.FieldLogo, vcard.FieldSound, vcard.FieldKey}
+
+// params4 exist only in 4.0 and are dropped on the way down.
+var params4 = []string{"PID", "ALTID", "MEDIATYPE", "CALSCALE", "SORT-AS", "GEO", "TZ", "INDEX", "LEVEL", "LABEL"}
+
+// cardVersion returns the card's version as one of vcardVersions; no VERSION reads as 3.0.
+func cardVersion(card vcard.Card) string {
+	v := strings.TrimSpace(card.Value(vcard.FieldVersion))
+	switch {
+	case strings.HasPrefix(v, "2"):
+		return "2.1"
+	case strings.HasPrefix(v, "4"):
+		return "4.0"
+	}
+	return "3.0"
+}
+
+// convertCard rewrites the card for version to (PREF, TYPE, PHOTO encoding, KIND and
+// friends, date forms) and reports whether anything changed. 2.1 differs from 3.0 only
+// on the wire (see encodeVCF21), so in memory it is converted like 3.0.
+func convertCard(card *vcard.Card, to string) bool {
+	from := cardVersion(*card)
+	if from == to && card.Value(vcard.FieldVersion) == to {
+		return false
+	}
+	switch {
+	case to == "4.0" && from != "4.0":
+		upgradeCard(card)
+	case to != "4.0" && from == "4.0":
+		downgradeCard(card)
+	}
+	card.SetValue(vcard.FieldVersion, to)
+	return true
+}
+
+// upgradeCard turns 2.1/3.0 properties into their 4.0 form.
+func upgradeCard(card *vcard.Card) {
+	for _, r := range versionRenames {
+		renameProp(card, r.v3, r.v4)
+	}
+	// 3.0 LABEL properties become the LABEL parameter of the address with the same TYPE
+	kept := []*vcard.Field{}
+	for _, l := range (*card)["LABEL"] {
+		attached := false
+		for _, adr := range (*card)[vcard.FieldAddress] {
+			if adr.Params == nil {
+				adr.Params = vcard.Params{}
+			}
+			if adr.Params.Get("LABEL") == "" && strings.EqualFold(strings.Join(adr.Params.Types(), ","), strings.Join(l.Params.Types(), ",")) {
+				adr.Params.Set("LABEL", l.Value)
+				attached = true
+				break
+			}
+		}
+		if !attached {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == 0 {
+		delete(*card, "LABEL")
+	} else {
+		(*card)["LABEL"] = kept
+	}
+	for name, fields := range *card {
+		for _, f := range fields {
+			if f.Params == nil {
+				f.Params = vcard.Params{}
+			}
+			delete(f.Params, "CHARSET")
+			if f.Params.HasType("pref") {
+				removeType(f.Params, "pref")
+				f.Params.Set(vcard.ParamPreferred, "1")
+			}
+			switch name {
+			case vcard.FieldEmail:
+				removeType(f.Params, "internet")
+			case vcard.FieldKind:
+				f.Value = strings.ToLower(f.Value)
+			case vcard.FieldGender:
+				if sex, ok := map[string]string{"male": "M", "female": "F", "other": "O", "none": "N", "unknown": "U"}[strings.ToLower(f.Value)]; ok {
+					f.Value = sex
+				}
+			case vcard.FieldBirthday, vcard.FieldAnniversary:
+				f.Value = basicDate(f.Value)
+			}
+			if containsString(mediaFields, name) {
+				enc := strings.ToUpper(f.Params.Get("ENCODING"))
+				if enc == "B" || enc == "BASE64" || strings.EqualFold(f.Params.Get(vcard.ParamValue), "binary") {
+					f.Value = "data:" + mediaType(name, f.Params.Get(vcard.ParamType)) + ";base64," + strings.Join(strings.Fields(f.Value), "")
+					delete(f.Params, "ENCODING")
+					delete(f.Params, vcard.ParamType)
+				}
+				// media are URIs in 4.0; say so, since the value is not escaped like text
+				f.Params.Set(vcard.ParamValue, "uri")
+			}
+		}
+	}
+}
+
+// downgradeCard turns 4.0 properties into their 3.0 form.
+func downgradeCard(card *vcard.Card) {
+	delete(*card, vcard.FieldClientPIDMap)
+	delete(*card, "XML")
+	if kind := strings.ToLower(card.Value(vcard.FieldKind)); kind == "" || kind == "individual" {
+		delete(*card, vcard.FieldKind)
+	}
+	for name, fields := range *card {
+		for _, f := range fields {
+			if f.Params == nil {
+				f.Params = vcard.Params{}
+			}
+			if f.Params.Get(vcard.ParamPreferred) != "" {
+				delete(f.Params, vcard.ParamPreferred)
+				if !f.Params.HasType("pref") {
+					f.Params.Add(vcard.ParamType, "pref")
+				}
+			}
+			if name == vcard.FieldAddress {
+				if label := f.Params.Get("LABEL"); label != "" {
+					card.Add("LABEL", &vcard.Field{Value: label, Params: vcard.Params{vcard.ParamType: f.Params[vcard.ParamType]}})
+				}
+			}
+			for _, p := range params4 {
+				delete(f.Params, p)
+			}
+			switch name {
+			case vcard.FieldTelephone:
+				if strings.EqualFold(f.Params.Get(vcard.ParamValue), "uri") {
+					f.Value = strings.TrimPrefix(strings.TrimPrefix(f.Value, "tel:"), "TEL:")
+					delete(f.Params, vcard.ParamValue)
+				}
+			case vcard.FieldGender:
+				sex, _, _ := strings.Cut(f.Value, ";")
+				if v, ok := map[string]string{"M": "Male", "F": "Female", "O": "Other", "N": "None", "U": "Unknown"}[strings.ToUpper(sex)]; ok {
+					f.Value = v
+				}
+			case vcard.FieldBirthday, vcard.FieldAnniversary:
+				f.Value = extendedDate(f.Value)
+			}
+			if containsString(mediaFields, name) {
+				if mime, data, ok := parseDataURI(f.Value); ok {
+					f.Value = data
+					delete(f.Params, vcard.ParamValue)
+					f.Params.Set("ENCODING", "b")
+					f.Params.Set(vcard.ParamType, strings.ToUpper(mime[strings.LastIndex(mime, "/")+1:]))
+				} else if strings.EqualFold(f.Params.Get(vcard.ParamValue), "binary") {
+					// what `photos` writes
+					delete(f.Params, vcard.ParamValue)
+					f.Params.Set("ENCODING", "b")
+				} else if strings.Contains(f.Value, "://") {
+					f.Params.Set(vcard.ParamValue, "uri")
+				}
+			}
+		}
+	}
+	for _, r := range versionRenames {
+		renameProp(card, r.v4, r.v3)
+	}
+	if card.Name() == nil {
+		// N is mandatory before 4.0
+		setStructuredName(card, card.Value(vcard.FieldFormattedName), "")
+	}
+}
+
+func renameProp(card *vcard.Card, from, to string) {
+	fields, ok := (*card)[from]
+	if !ok {
+		return
+	}
+	delete(*card, from)
+	if len((*card)[to]) == 0 {
+		(*card)[to] = fields
+	}
+}
+
+// mediaType guesses the MIME type of 3.0 media from its TYPE (JPEG, PNG, ...).
+func mediaType(field, typ string) string {
+	typ = strings.ToLower(typ)
+	if typ == "" {
+		typ = "jpeg"
+	}
+	if strings.Contains(typ, "/") {
+		return typ
+	}
+	if field == vcard.FieldSound {
+		return "audio/" + typ
+	}
+	if field == vcard.FieldKey {
+		return "application/" + typ
+	}
+	return "image/" + typ
+}
+
+// parseDataURI splits "data:image/jpeg;base64,XXXX".
+func parseDataURI(s string) (mime, data string, ok bool) {
+	if !strings.HasPrefix(s, "data:") {
+		return "", "", false
+	}
+	head, data, found := strings.Cut(s[len("data:"):], ",")
+	if !found || !strings.HasSuffix(head, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(head, ";base64"), data, true
+}
+
+// basicDate writes ISO dates the 4.0 way: "1990-05-17" -> "19900517", "--05-17" -> "--0517".
+func basicDate(s string) string {
+	date, rest, _ := strings.Cut(s, "T")
+	if strings.HasPrefix(date, "--") {
+		date = "--" + strings.ReplaceAll(date[2:], "-", "")
+	} else if len(date) == len("2006-01-02") && date[4] == '-' && date[7] == '-' {
+		date = strings.ReplaceAll(date, "-", "")
+	}
+	if rest != "" {
+		return date + "T" + rest
+	}
+	return date
+}
+
+// extendedDate is the reverse of basicDate, the form 3.0 clients expect.
+func extendedDate(s string) string {
+	date, rest, _ := strings.Cut(s, "T")
+	switch {
+	case len(date) == len("--0517") && strings.HasPrefix(date, "--"):
+		date = "--" + date[2:4] + "-" + date[4:]
+	case len(date) == len("19900517") && !strings.Contains(date, "-"):
+		date = date[:4] + "-" + date[4:6] + "-" + date[6:]
+	}
+	if rest != "" {
+		return date + "T" + rest
+	}
+	return date
+}
+
+// unescapeURIValues undoes go-vcard's comma escaping on URI-valued lines of encoded
+// vCard text. The encoder treats every value as text, which turns a data: PHOTO into
+// "data:image/png;base64\,..."; URI values are written verbatim (RFC 6350 section 4).
+func unescapeURIValues(text string) string {
+	lines := strings.Split(text, "\r\n")
+	for i, line := range lines {
+		name, params, value, ok := splitVCFLine(line)
+		if !ok || !strings.Contains(value, `\,`) {
+			continue
+		}
+		if _, n, grouped := strings.Cut(name, "."); grouped {
+			name = n
+		}
+		typ := defaultValueType(strings.ToUpper(name))
+		for _, p := range params {
+			if k, v, _ := strings.Cut(p, "="); strings.EqualFold(k, vcard.ParamValue) {
+				typ = strings.ToLower(v)
+			}
+		}
+		if typ != "uri" {
+			continue
+		}
+		var b strings.Builder
+		for j := 0; j < len(value); j++ {
+			if value[j] == '\\' && j+1 < len(value) {
+				j++
+				if value[j] != ',' {
+					b.WriteByte('\\') // keep \\ and \n as escaped
+				}
+			}
+			b.WriteByte(value[j])
+		}
+		lines[i] = line[:len(line)-len(value)] + b.String()
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+// encodeVCF21 writes cards as vCard 2.1: bare TYPE values, ENCODING=BASE64 for media,
+// and quoted-printable UTF-8 for values that are not plain ASCII on one line.
+func encodeVCF21(cards []vcard.Card) string {
+	var b strings.Builder
+	for _, c := range cards {
+		c = copyCard(c)
+		convertCard(&c, "2.1")
+		b.WriteString("BEGIN:VCARD\r\nVERSION:2.1\r\n")
+		keys := make([]string, 0, len(c))
+		for k := range c {
+			if k != vcard.FieldVersion {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, f := range c[k] {
+				b.WriteString(formatLine21(k, f))
+			}
+		}
+		b.WriteString("END:VCARD\r\n")
+	}
+	return b.String()
+}
+
+func formatLine21(key string, f *vcard.Field) string {
+	head := key
+	if f.Group != "" {
+		head = f.Group + "." + key
+	}
+	pkeys := make([]string, 0, len(f.Params))
+	for k := range f.Params {
+		pkeys = append(pkeys, k)
+	}
+	sort.Strings(pkeys)
+	media := false
+	for _, k := range pkeys {
+		for _, v := range f.Params[k] {
+			switch {
+			case k == vcard.ParamType:
+				head += ";" + strings.ToUpper(v)
+			case k == "ENCODING":
+				media = true
+				head += ";ENCODING=BASE64"
+			default:
+				head += ";" + k + "=" + v
+			}
+		}
+	}
+	if media {
+		// 2.1 base64: folded with leading spaces and closed by an empty line
+		data := strings.Join(strings.Fields(f.Value), "")
+		var b strings.Builder
+		b.WriteString(head + ":\r\n")
+		for len(data) > 0 {
+			n := min(72, len(data))
+			b.WriteString(" " + data[:n] + "\r\n")
+			data = data[n:]
+		}
+		return b.String() + "\r\n"
+	}
+	plain := true
+	for _, r := range f.Value {
+		if r > 126 || r == '\n' || r == '\r' {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return head + ":" + f.Value + "\r\n"
+	}
+	var qp strings.Builder
+	w := quotedprintable.NewWriter(&qp)
+	w.Binary = true // line breaks inside the value are data (=0D=0A), not soft breaks
+	w.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(f.Value, "\r\n", "\n"), "\n", "\r\n")))
+	w.Close()
+	// the writer breaks lines with "=\r\n" at 76 columns, which is what 2.1 readers expect
+	return head + ";CHARSET=UTF-8;ENCODING=QUOTED-PRINTABLE:" + qp.String() + "\r\n"
+}
+
+// collectionVersion is the version the rules file asks for on PUTs to collection (a path
+// like RADICALE_COLLECTION, or its last segment): vcard.collections, else vcard.version.
+// "" keeps every card's own version.
+func collectionVersion(collection string) string {
+	cfg := rules().VCard
+	collection = strings.Trim(collection, "/")
+	for k, v := range cfg.Collections {
+		k = strings.Trim(k, "/")
+		if k == collection || k == collection[strings.LastIndex(collection, "/")+1:] {
+			return v
+		}
+	}
+	return cfg.Version
+}
+
+// newCardVersion is the VERSION given to cards created in c's collection.
+func (c *radClient) newCardVersion() string {
+	if v := collectionVersion(c.collection); v != "" {
+		return v
+	}
+	return "4.0"
+}
+
+// runConvert implements `convert --to V`: server and/or bucket cards are rewritten in
+// version V (apply=false only counts), or with file a single .vcf is converted to out.
+func runConvert(to, scope, file, out string, apply bool) {
+	if !containsString(vcardVersions, to) {
+		log.Fatalf("convert: --to must be one of %s", strings.Join(vcardVersions, ", "))
+	}
+	if file != "" {
+		convertFile(file, out, to)
+		return
+	}
+	if to == "2.1" {
+		log.Fatalf("convert: 2.1 is only written to files (--file); CardDAV and the buckets need 3.0 or 4.0")
+	}
+	if scope != "all" && scope != "server" && scope != "buckets" {
+		log.Fatalf("convert: --scope must be all, server or buckets")
+	}
+	if scope != "buckets" {
+		client := newClient()
+		if v := collectionVersion(client.collection); v != "" && v != to {
+			log.Fatalf("convert: the rules file pins %s to vCard %s (vcard: in %s); every PUT would convert back", client.collection, v, getenv("RULES_FILE", "dav-rules.yaml"))
+		}
+		ctx := context.Background()
+		n := 0
+		for _, cd := range mustFetch(client) {
+			from := cardVersion(cd.Card)
+			if !convertCard(&cd.Card, to) {
+				continue
+			}
+			n++
+			log.Printf("convert %s: %s -> %s", cd.Card.Value(vcard.FieldFormattedName), from, to)
+			if apply {
+				if err := client.put(ctx, cd.Ref, cd.Card); err != nil {
+					log.Printf("convert put %s: %v", cd.Ref.Href, err)
+				}
+			}
+		}
+		log.Printf("convert: %d server card(s) to vCard %s. apply=%v", n, to, apply)
+	}
+	if scope != "server" {
+		files, n := 0, 0
+		root := bucketRoot()
+		for _, bucket := range bucketNames(root) {
+			for _, path := range bucketVCFs(filepath.Join(root, bucket)) {
+				cards, err := readVCF(path)
+				if err != nil {
+					log.Printf("convert: skip %s: %v", path, err)
+					continue
+				}
+				changed := 0
+				for i := range cards {
+					if convertCard(&cards[i], to) {
+						changed++
+					}
+				}
+				if changed == 0 {
+					continue
+				}
+				files++
+				n += changed
+				log.Printf("convert %s: %d card(s) -> %s", path, changed, to)
+				if apply {
+					logBucketErr("convert: write", path, writeVCF(path, cards))
+				}
+			}
+		}
+		log.Printf("convert: %d bucket card(s) in %d file(s) to vCard %s. apply=%v", n, files, to, apply)
+	}
+}
+
+// convertFile converts every card of a .vcf (any version) and writes it to out or stdout.
+func convertFile(file, out, to string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("convert: %v", err)
+	}
+	cards, err := decodeVCFText(data)
+	if err != nil {
+		log.Fatalf("convert: %s: %v", file, err)
+	}
+	var text string
+	if to == "2.1" {
+		text = encodeVCF21(cards)
+	} else {
+		for i := range cards {
+			convertCard(&cards[i], to)
+		}
+		text = string(encodeCards(cards))
+	}
+	if out == "" || out == "-" {
+		fmt.Print(text)
+		return
+	}
+	if err := os.WriteFile(out, []byte(text), 0o644); err != nil {
+		log.Fatalf("convert: %v", err)
+	}
+	log.Printf("convert: %d card(s) written to %s as vCard %s", len(cards), out, to)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	vcard "github.com/emersion/go-vcard"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted bucket files keep their .vcf name but start with bucketCryptMagic, followed by
+// a 16-byte scrypt salt, a 12-byte nonce and the AES-256-GCM sealed VCF text.
+const bucketCryptMagic = "DAV-ENC-1\n"
+
+const (
+	cryptSaltLen = 16
+	scryptN      = 1 << 15
+)
+
+var errNoPassphrase = errors.New("file is encrypted; set BUCKET_PASSPHRASE or BUCKET_PASSPHRASE_FILE")
+
+var (
+	cryptMu   sync.Mutex
+	cryptKeys = map[string][]byte{} // scrypt is slow on purpose: one derivation per salt
+	writeSalt []byte                // files written in one run share a salt
+)
+
+// bucketPassphrase returns BUCKET_PASSPHRASE, or the first line of BUCKET_PASSPHRASE_FILE.
+// Empty means buckets are written in plaintext.
+func bucketPassphrase() string {
+	if p := os.Getenv("BUCKET_PASSPHRASE"); p != "" {
+		return p
+	}
+	if path := os.Getenv("BUCKET_PASSPHRASE_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("BUCKET_PASSPHRASE_FILE: %v", err)
+		}
+		return strings.TrimRight(strings.SplitN(string(data), "\n", 2)[0], "\r")
+	}
+	return ""
+}
+
+// encryptBuckets reports whether bucket writes are encrypted.
+func encryptBuckets() bool { return bucketPassphrase() != "" }
+
+func isEncrypted(data []byte) bool { return bytes.HasPrefix(data, []byte(bucketCryptMagic)) }
+
+func cryptKey(salt []byte) ([]byte, error) {
+	pass := bucketPassphrase()
+	if pass == "" {
+		return nil, errNoPassphrase
+	}
+	cryptMu.Lock()
+	defer cryptMu.Unlock()
+	if k, ok := cryptKeys[string(salt)]; ok {
+		return k, nil
+	}
+	k, err := scrypt.Key([]byte(pass), salt, scryptN, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	cryptKeys[string(salt)] = k
+	return k, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealBucketData encrypts plain with the bucket passphrase.
+func sealBucketData(plain []byte) ([]byte, error) {
+	cryptMu.Lock()
+	if writeSalt == nil {
+		writeSalt = make([]byte, cryptSaltLen)
+		if _, err := rand.Read(writeSalt); err != nil {
+			cryptMu.Unlock()
+			return nil, err
+		}
+	}
+	salt := writeSalt
+	cryptMu.Unlock()
+	key, err := cryptKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(bucketCryptMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, []byte(bucketCryptMagic)), nil
+}
+
+// openBucketData returns the VCF text of a bucket file, decrypting it when needed.
+func openBucketData(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	rest := data[len(bucketCryptMagic):]
+	if len(rest) < cryptSaltLen {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	key, err := cryptKey(rest[:cryptSaltLen])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[cryptSaltLen:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	plain, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], []byte(bucketCryptMagic))
+	if err != nil {
+		return nil, errors.New("cannot decrypt (wrong passphrase or corrupted file)")
+	}
+	return plain, nil
+}
+
+// migrateBucketCrypto implements `buckets encrypt|decrypt`: every VCF under UN_CONTACTS,
+// .trash included, is rewritten encrypted (or in plaintext). Files already in the wanted
+// form are left alone; apply=false only counts.
+func migrateBucketCrypto(encrypt, apply bool) {
+	op := "buckets decrypt"
+	if encrypt {
+		op = "buckets encrypt"
+	}
+	if bucketPassphrase() == "" {
+		log.Fatalf("%s: set BUCKET_PASSPHRASE or BUCKET_PASSPHRASE_FILE", op)
+	}
+	root := bucketRoot()
+	var todo, done, failed int
+	walkStore(root, true, func(path string, _ storeEntry) {
+		if strings.ToLower(filepath.Ext(path)) != ".vcf" {
+			return
+		}
+		data, err := storeRead(path)
+		if err != nil {
+			log.Printf("%s: %s: %v", op, path, err)
+			failed++
+			return
+		}
+		if isEncrypted(data) == encrypt {
+			return
+		}
+		todo++
+		if !apply {
+			return
+		}
+		cards, err := readVCF(path)
+		if err != nil {
+			log.Printf("%s: skip %s: %v", op, path, err)
+			failed++
+			return
+		}
+		if err := writeVCFAs(path, cards, encrypt); err != nil {
+			log.Printf("%s: %s: %v", op, path, err)
+			failed++
+			return
+		}
+		done++
+	})
+	if apply {
+		bucketIndexFor(root) // re-cache in the new form
+	}
+	log.Printf("%s: %d file(s) to convert, %d converted, %d failed. apply=%v", op, todo, done, failed, apply)
+	if failed > 0 {
+		closeBuckets()
+		os.Exit(1)
+	}
+}
+
+// encodeCards concatenates the cards as VCF text, REV untouched.
+func encodeCards(cards []vcard.Card) []byte {
+	var b strings.Builder
+	for _, c := range cards {
+		b.WriteString(encodeCard(c))
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// trashDir is the hidden quarantine under UN_CONTACTS; bucket listings never look inside.
+// Each run that quarantines cards gets its own trashStampLayout-named batch directory.
+const (
+	trashDir         = ".trash"
+	trashStampLayout = "20060102-150405"
+)
+
+// bucketCard is one card of a bucket VCF, addressed by file and position.
+type bucketCard struct {
+	Bucket string
+	Path   string
+	Index  int
+	Card   vcard.Card
+}
+
+// loadBucketCards returns every card of every bucket under root, in bucket/file order,
+// via the bucket index (only changed files are re-read).
+func loadBucketCards(root string) []bucketCard {
+	return bucketIndexFor(root).cards()
+}
+
+// cardWeight ranks duplicates: the card carrying the most properties survives.
+func cardWeight(card vcard.Card) int {
+	n := 0
+	for _, fields := range card {
+		n += len(fields)
+	}
+	return n
+}
+
+// dedupeBuckets finds cards duplicated within or across buckets (shared name, email or
+// phone), merges each cluster into its richest card and quarantines the others under
+// UN_CONTACTS/.trash/<timestamp>/<bucket>/ with a report.md. Clusters whose cards carry
+// different names are only reported. apply=false only reports.
+func dedupeBuckets(apply bool) {
+	root := bucketRoot()
+	all := loadBucketCards(root)
+	plain := make([]vcard.Card, len(all))
+	for i, bc := range all {
+		plain[i] = bc.Card
+	}
+	groups, crossName := [][]int{}, [][]int{}
+	for _, g := range duplicateGroups(plain) {
+		same, mixed := nameClusters(plain, g)
+		groups = append(groups, same...)
+		if mixed {
+			crossName = append(crossName, g)
+		}
+	}
+	if len(groups) == 0 && len(crossName) == 0 {
+		log.Printf("buckets dedupe: no duplicates")
+		return
+	}
+
+	stamp := time.Now().Format(trashStampLayout)
+	quarantine := filepath.Join(root, trashDir, stamp)
+	rel := func(p string) string {
+		if r, err := filepath.Rel(root, p); err == nil {
+			return r
+		}
+		return p
+	}
+	// per file: replacement cards by index and indexes to drop
+	replace := map[string]map[int]vcard.Card{}
+	drop := map[string]map[int]bool{}
+	report := []string{"# Bucket dedupe " + stamp + "\n\n", "| Kept | Quarantined | Name |\n", "|---|---|---|\n"}
+	losers := 0
+	for n, group := range groups {
+		keep := group[0]
+		for _, i := range group[1:] {
+			if cardWeight(all[i].Card) > cardWeight(all[keep].Card) {
+				keep = i
+			}
+		}
+		winner := all[keep]
+		merged := copyCard(winner.Card)
+		fmt.Printf("cluster %d: keep %s (%s)\n", n+1, rel(winner.Path), winner.Card.Value(vcard.FieldFormattedName))
+		for _, i := range group {
+			if i == keep {
+				continue
+			}
+			loser := all[i]
+			src := copyCard(loser.Card)
+			clearProvenance(&src)
+			if !strings.EqualFold(loser.Bucket, winner.Bucket) {
+				removeCategories(&src, loser.Bucket)
+			}
+			mergeCards(&merged, src)
+			losers++
+			fmt.Printf("  merge + quarantine %s (%s)\n", rel(loser.Path), loser.Card.Value(vcard.FieldFormattedName))
+			report = append(report, fmt.Sprintf("| %s | %s | %s |\n", rel(winner.Path), rel(loser.Path), loser.Card.Value(vcard.FieldFormattedName)))
+			if !apply {
+				continue
+			}
+			if _, err := quarantineCard(quarantine, loser.Bucket, loser.Card); err != nil {
+				log.Fatalf("buckets dedupe: quarantine %s: %v", loser.Path, err)
+			}
+			if drop[loser.Path] == nil {
+				drop[loser.Path] = map[int]bool{}
+			}
+			drop[loser.Path][loser.Index] = true
+		}
+		if encodeCard(merged) != encodeCard(winner.Card) {
+			setRevNow(&merged)
+			if replace[winner.Path] == nil {
+				replace[winner.Path] = map[int]vcard.Card{}
+			}
+			replace[winner.Path][winner.Index] = merged
+		}
+	}
+
+	if len(crossName) > 0 {
+		report = append(report, "\n## Not merged: shared phone or email, different names\n\n")
+	}
+	for _, g := range crossName {
+		names := []string{}
+		for _, i := range g {
+			names = append(names, fmt.Sprintf("%s (%s)", all[i].Card.Value(vcard.FieldFormattedName), rel(all[i].Path)))
+		}
+		fmt.Printf("not merged (different names): %s\n", strings.Join(names, ", "))
+		report = append(report, "- "+strings.Join(names, ", ")+"\n")
+	}
+
+	if apply && len(groups) > 0 {
+		rewriteBucketFiles("buckets dedupe", drop, replace)
+		writeTrashReport("buckets dedupe", quarantine, report)
+	}
+	log.Printf("buckets dedupe: %d cluster(s), %d card(s) merged and quarantined, %d cross-name match(es) left alone. apply=%v",
+		len(groups), losers, len(crossName), apply)
+}
+
+// nameClusters splits a duplicate group by formatted name, as samePerson does: cards that
+// share a phone or email under different names (a family landline, an office switchboard)
+// are not merged. Nameless cards only join a group that carries a single name. mixed
+// reports whether the group held more than one name.
+func nameClusters(cards []vcard.Card, group []int) (same [][]int, mixed bool) {
+	byName := map[string][]int{}
+	order := []string{}
+	for _, i := range group {
+		fn := norm(cards[i].Value(vcard.FieldFormattedName))
+		if fn == "" {
+			continue
+		}
+		if _, ok := byName[fn]; !ok {
+			order = append(order, fn)
+		}
+		byName[fn] = append(byName[fn], i)
+	}
+	if len(order) <= 1 {
+		return [][]int{group}, false
+	}
+	for _, fn := range order {
+		if len(byName[fn]) > 1 {
+			same = append(same, byName[fn])
+		}
+	}
+	return same, true
+}
+
+// quarantineCard writes card to batch/<bucket>/<name>.vcf and returns that path.
+func quarantineCard(batch, bucket string, card vcard.Card) (string, error) {
+	p := uniqueBucketPath(filepath.Join(batch, bucket), card.Value(vcard.FieldFormattedName))
+	return p, writeVCF(p, []vcard.Card{card})
+}
+
+// rewriteBucketFiles rewrites each touched VCF once, dropping and replacing cards by
+// their index in the file.
+func rewriteBucketFiles(op string, drop map[string]map[int]bool, replace map[string]map[int]vcard.Card) {
+	files := map[string]bool{}
+	for p := range replace {
+		files[p] = true
+	}
+	for p := range drop {
+		files[p] = true
+	}
+	for p := range files {
+		cards, err := readVCF(p)
+		if err != nil {
+			log.Printf("%s: skip rewrite of %s: %v", op, p, err)
+			continue
+		}
+		kept := []vcard.Card{}
+		for i, c := range cards {
+			if drop[p][i] {
+				continue
+			}
+			if r, ok := replace[p][i]; ok {
+				c = r
+			}
+			kept = append(kept, c)
+		}
+		logBucketErr(op+": write", p, writeVCF(p, kept))
+	}
+}
+
+// writeTrashReport saves the markdown report next to the quarantined cards.
+func writeTrashReport(op, batch string, lines []string) {
+	p := filepath.Join(batch, "report.md")
+	if err := storeWrite(p, []byte(strings.Join(lines, ""))); err != nil {
+		log.Printf("%s: report: %v", op, err)
+		return
+	}
+	log.Printf("%s: report at %s", op, p)
+}
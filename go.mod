@@ -2,4 +2,11 @@ module github.com/user/dav-manager
 
 go 1.21
 
-require github.com/emersion/go-vcard v0.0.0-20241024213814-c9703dde27ff // indirect
+require github.com/emersion/go-vcard v0.0.0-20241024213814-c9703dde27ff
+
+require (
+	golang.org/x/crypto v0.22.0
+	golang.org/x/net v0.24.0
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// Google Contacts CSV: one row per contact. Multi-valued properties come as numbered
+// column groups ("Phone 1 - Label", "Phone 1 - Value", ...), several values in one cell are
+// joined by googleSep, and a "* " label prefix marks the primary value. Both the current
+// export ("Labels", "Organization Name") and the legacy one ("Group Membership",
+// "Organization 1 - Name", "... - Type") are read; export writes the current one.
+const googleSep = " ::: "
+
+// googleSystemGroup is the label every Google contact carries; it never becomes a category.
+const googleSystemGroup = "myContacts"
+
+// googlePhoneLabels maps Google phone labels to STANDARDS.md labels.
+var googlePhoneLabels = map[string]string{
+	"mobile": "cell", "work": "work", "home": "home", "main": "work", "other": "other",
+	"work fax": "fax", "home fax": "fax", "fax": "fax", "pager": "pager",
+}
+
+// googleRow reads cells of one CSV row by (case-insensitive) column name.
+type googleRow struct {
+	cols  map[string]int
+	cells []string
+}
+
+func (r googleRow) get(names ...string) string {
+	for _, n := range names {
+		if i, ok := r.cols[strings.ToLower(n)]; ok && i < len(r.cells) {
+			if v := strings.TrimSpace(r.cells[i]); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// group returns one cell of numbered column group n, e.g. ("Phone", 1, "Value").
+func (r googleRow) group(kind string, n int, field string) string {
+	p := fmt.Sprintf("%s %d - ", kind, n)
+	return r.get(p + field)
+}
+
+// groups counts the numbered column groups of kind present in the header.
+func (r googleRow) groups(kind string) int {
+	n := 0
+	for ; n < 100; n++ {
+		found := false
+		for _, f := range []string{"value", "name", "street", "formatted"} {
+			if _, ok := r.cols[strings.ToLower(fmt.Sprintf("%s %d - %s", kind, n+1, f))]; ok {
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return n
+}
+
+// splitGoogle splits a multi-value cell.
+func splitGoogle(s string) []string {
+	out := []string{}
+	for _, v := range strings.Split(s, ":::") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// googleLabel returns the lowercased label and whether it was marked primary ("* Mobile").
+func googleLabel(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	pref := strings.HasPrefix(s, "* ")
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(s, "* "))), pref
+}
+
+// readGoogleCSV parses a Google Contacts export into normalized vCards; sources names the
+// CSV row of each card for the import plan.
+func readGoogleCSV(r io.Reader) (cards []vcard.Card, sources []string, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if _, ok := cols[h]; !ok {
+			cols[h] = i
+		}
+	}
+	if _, ok := cols["phone 1 - value"]; !ok {
+		if _, ok := cols["e-mail 1 - value"]; !ok {
+			return nil, nil, fmt.Errorf("not a Google Contacts CSV (no \"Phone 1 - Value\" or \"E-mail 1 - Value\" column)")
+		}
+	}
+	for {
+		cells, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cards, sources, err // *csv.ParseError names the line
+		}
+		// the line the record starts on: quoted Notes may span several
+		line, _ := cr.FieldPos(0)
+		card, err := googleRowCard(googleRow{cols: cols, cells: cells})
+		if err != nil {
+			log.Printf("import: skip row %d: %v", line, err)
+			continue
+		}
+		if card == nil {
+			continue
+		}
+		cards = append(cards, card)
+		sources = append(sources, fmt.Sprintf("row %d", line))
+	}
+	return cards, sources, nil
+}
+
+// googleRowCard maps one CSV row; empty rows yield nil.
+func googleRowCard(r googleRow) (vcard.Card, error) {
+	card := vcard.Card{}
+	card.SetValue(vcard.FieldVersion, "4.0")
+
+	given := r.get("First Name", "Given Name")
+	additional := r.get("Middle Name", "Additional Name")
+	family := r.get("Last Name", "Family Name")
+	prefix, suffix := r.get("Name Prefix"), r.get("Name Suffix")
+	org := r.get("Organization Name", "Organization 1 - Name")
+
+	emails := []*vcard.Field{}
+	for n := 1; n <= r.groups("E-mail"); n++ {
+		label, pref := googleLabel(r.get(fmt.Sprintf("E-mail %d - Label", n), fmt.Sprintf("E-mail %d - Type", n)))
+		for _, v := range splitGoogle(r.group("E-mail", n, "Value")) {
+			f := &vcard.Field{Value: v, Params: vcard.Params{}}
+			if containsString(allowedEmailLabels(), label) {
+				f.Params.Add(vcard.ParamType, label)
+			}
+			if pref {
+				f.Params.Set(vcard.ParamPreferred, "1")
+			}
+			emails = append(emails, f)
+		}
+	}
+	phones := []*vcard.Field{}
+	for n := 1; n <= r.groups("Phone"); n++ {
+		label, pref := googleLabel(r.get(fmt.Sprintf("Phone %d - Label", n), fmt.Sprintf("Phone %d - Type", n)))
+		if l, ok := googlePhoneLabels[label]; ok {
+			label = l
+		} else if label != "" {
+			label = "other"
+		}
+		for _, v := range splitGoogle(r.group("Phone", n, "Value")) {
+			f := &vcard.Field{Value: v, Params: vcard.Params{}}
+			if containsString(allowedPhoneLabels(), label) {
+				f.Params.Add(vcard.ParamType, label)
+			}
+			if pref {
+				f.Params.Set(vcard.ParamPreferred, "1")
+			}
+			phones = append(phones, f)
+		}
+	}
+
+	fn := r.get("Name")
+	if fn == "" {
+		fn = strings.Join(strings.Fields(strings.Join([]string{given, additional, family}, " ")), " ")
+	}
+	if fn == "" {
+		fn = org
+	}
+	if fn == "" && len(emails) > 0 {
+		fn = strings.ToLower(emails[0].Value)
+	}
+	if fn == "" && len(phones) > 0 {
+		fn = phones[0].Value
+	}
+	if fn == "" {
+		return nil, nil
+	}
+	card.SetValue(vcard.FieldFormattedName, fn)
+	if v := r.get("Notes"); v != "" {
+		card.SetValue(vcard.FieldNote, v)
+	}
+	normalizeDisplayName(&card)
+	// Google's own name columns win over what the FN parser would make of the name
+	override := ""
+	if given != "" || family != "" {
+		parts := []string{family, given, additional}
+		for i, p := range parts {
+			parts[i], _ = displayName(p)
+		}
+		override = strings.Join(append(parts, prefix, suffix), ";")
+	}
+	if err := setStructuredName(&card, card.Value(vcard.FieldFormattedName), override); err != nil {
+		return nil, err
+	}
+	setEmails(&card, emails)
+	setPhones(&card, phones)
+
+	if v := r.get("Nickname"); v != "" {
+		card.SetValue(vcard.FieldNickname, v)
+	}
+	if org != "" {
+		if dept := r.get("Organization Department", "Organization 1 - Department"); dept != "" {
+			org += ";" + dept
+		}
+		card.SetValue(vcard.FieldOrganization, org)
+	}
+	if v := r.get("Organization Title", "Organization 1 - Title"); v != "" {
+		card.SetValue(vcard.FieldTitle, v)
+	}
+	if v := r.get("Birthday"); v != "" {
+		card.SetValue(vcard.FieldBirthday, googleToBday(v))
+	}
+	for n := 1; n <= r.groups("Address"); n++ {
+		label, pref := googleLabel(r.get(fmt.Sprintf("Address %d - Label", n), fmt.Sprintf("Address %d - Type", n)))
+		street := r.group("Address", n, "Street")
+		adr := &vcard.Address{
+			PostOfficeBox:   r.group("Address", n, "PO Box"),
+			ExtendedAddress: r.group("Address", n, "Extended Address"),
+			StreetAddress:   street,
+			Locality:        r.group("Address", n, "City"),
+			Region:          r.group("Address", n, "Region"),
+			PostalCode:      r.group("Address", n, "Postal Code"),
+			Country:         r.group("Address", n, "Country"),
+		}
+		if *adr == (vcard.Address{}) {
+			// only the one-line form: keep it whole rather than guess at its parts
+			adr.StreetAddress = strings.ReplaceAll(r.group("Address", n, "Formatted"), "\n", ", ")
+		}
+		if *adr == (vcard.Address{}) {
+			continue
+		}
+		adr.Field = &vcard.Field{Params: vcard.Params{}}
+		if label == "home" || label == "work" {
+			adr.Field.Params.Add(vcard.ParamType, label)
+		}
+		if pref {
+			adr.Field.Params.Set(vcard.ParamPreferred, "1")
+		}
+		card.AddAddress(adr)
+	}
+	for n := 1; n <= r.groups("Website"); n++ {
+		for _, v := range splitGoogle(r.group("Website", n, "Value")) {
+			card.Add(vcard.FieldURL, &vcard.Field{Value: v})
+		}
+	}
+	cats := []string{}
+	for _, g := range splitGoogle(r.get("Labels", "Group Membership")) {
+		if g = strings.TrimSpace(strings.TrimPrefix(g, "* ")); g != "" && g != googleSystemGroup {
+			cats = append(cats, g)
+		}
+	}
+	addCategories(&card, cats...)
+	return card, nil
+}
+
+// googleToBday turns Google's "1990-05-17" / "--05-17" into vCard 4 "19900517" / "--0517".
+func googleToBday(s string) string {
+	if strings.HasPrefix(s, "--") {
+		return "--" + strings.ReplaceAll(s[2:], "-", "")
+	}
+	if len(s) == len("2006-01-02") && s[4] == '-' && s[7] == '-' {
+		return strings.ReplaceAll(s, "-", "")
+	}
+	return s
+}
+
+// bdayToGoogle is the reverse of googleToBday; vCard 3 dates are already in Google's form.
+func bdayToGoogle(s string) string {
+	s = strings.SplitN(s, "T", 2)[0]
+	switch {
+	case len(s) == len("--0517") && strings.HasPrefix(s, "--"):
+		return "--" + s[2:4] + "-" + s[4:]
+	case len(s) == len("19900517"):
+		if _, err := strconv.Atoi(s); err == nil {
+			return s[:4] + "-" + s[4:6] + "-" + s[6:]
+		}
+	}
+	return s
+}
+
+// googlePhoneLabel is the Google label written for a TEL field.
+func googlePhoneLabel(f *vcard.Field) string {
+	switch phoneLabel(f) {
+	case "cell", "":
+		return "Mobile"
+	case "fax":
+		if f.Params.HasType("home") {
+			return "Home Fax"
+		}
+		return "Work Fax"
+	default:
+		return upperFirst(phoneLabel(f))
+	}
+}
+
+// writeGoogleCSV writes cards in the current Google Contacts export layout.
+func writeGoogleCSV(w io.Writer, cards []vcard.Card) error {
+	nEmail, nPhone, nAdr, nURL := 1, 1, 1, 1
+	for _, c := range cards {
+		nEmail = max(nEmail, len(c[vcard.FieldEmail]))
+		nPhone = max(nPhone, len(c[vcard.FieldTelephone]))
+		nAdr = max(nAdr, len(c[vcard.FieldAddress]))
+		nURL = max(nURL, len(c[vcard.FieldURL]))
+	}
+	header := []string{"First Name", "Middle Name", "Last Name", "Name Prefix", "Name Suffix", "Nickname",
+		"File As", "Organization Name", "Organization Title", "Organization Department", "Birthday", "Notes", "Labels"}
+	for i := 1; i <= nEmail; i++ {
+		header = append(header, fmt.Sprintf("E-mail %d - Label", i), fmt.Sprintf("E-mail %d - Value", i))
+	}
+	for i := 1; i <= nPhone; i++ {
+		header = append(header, fmt.Sprintf("Phone %d - Label", i), fmt.Sprintf("Phone %d - Value", i))
+	}
+	adrCols := []string{"Label", "Formatted", "Street", "City", "PO Box", "Region", "Postal Code", "Country", "Extended Address"}
+	for i := 1; i <= nAdr; i++ {
+		for _, c := range adrCols {
+			header = append(header, fmt.Sprintf("Address %d - %s", i, c))
+		}
+	}
+	for i := 1; i <= nURL; i++ {
+		header = append(header, fmt.Sprintf("Website %d - Label", i), fmt.Sprintf("Website %d - Value", i))
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, c := range cards {
+		n := cardName(c)
+		org := strings.SplitN(c.Value(vcard.FieldOrganization), ";", 2)
+		dept := ""
+		if len(org) > 1 {
+			dept = org[1]
+		}
+		labels := []string{"* " + googleSystemGroup}
+		labels = append(labels, cardCategories(c)...)
+		row := []string{n.Given, n.Additional, n.Family, n.Prefix, n.Suffix, c.Value(vcard.FieldNickname),
+			c.Value(vcard.FieldFormattedName), org[0], c.Value(vcard.FieldTitle), dept,
+			bdayToGoogle(c.Value(vcard.FieldBirthday)), c.Value(vcard.FieldNote), strings.Join(labels, googleSep)}
+		for i := 0; i < nEmail; i++ {
+			label, value := "", ""
+			if i < len(c[vcard.FieldEmail]) {
+				f := c[vcard.FieldEmail][i]
+				label, value = googlePrimary(f, upperFirst(emailLabel(f))), f.Value
+			}
+			row = append(row, label, value)
+		}
+		for i := 0; i < nPhone; i++ {
+			label, value := "", ""
+			if i < len(c[vcard.FieldTelephone]) {
+				f := c[vcard.FieldTelephone][i]
+				label, value = googlePrimary(f, googlePhoneLabel(f)), f.Value
+			}
+			row = append(row, label, value)
+		}
+		adrs := c.Addresses()
+		for i := 0; i < nAdr; i++ {
+			if i >= len(adrs) {
+				row = append(row, make([]string, len(adrCols))...)
+				continue
+			}
+			a := adrs[i]
+			label := ""
+			for _, t := range []string{"home", "work"} {
+				if a.Field.Params.HasType(t) {
+					label = upperFirst(t)
+				}
+			}
+			formatted := strings.Join(nonEmpty(a.StreetAddress, a.ExtendedAddress, a.Locality, a.Region, a.PostalCode, a.Country), ", ")
+			row = append(row, googlePrimary(a.Field, label), formatted, a.StreetAddress, a.Locality, a.PostOfficeBox,
+				a.Region, a.PostalCode, a.Country, a.ExtendedAddress)
+		}
+		for i := 0; i < nURL; i++ {
+			value := ""
+			if i < len(c[vcard.FieldURL]) {
+				value = c[vcard.FieldURL][i].Value
+			}
+			row = append(row, "", value)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// googlePrimary prefixes the label of a preferred value with "* ", as Google does.
+func googlePrimary(f *vcard.Field, label string) string {
+	if f != nil && isPreferred(f) {
+		return "* " + label
+	}
+	return label
+}
+
+// emailLabel returns the EMAIL field's label ("" when untyped).
+func emailLabel(f *vcard.Field) string {
+	for _, t := range f.Params.Types() {
+		if containsString(emailLabels, strings.ToLower(t)) {
+			return strings.ToLower(t)
+		}
+	}
+	return ""
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func nonEmpty(vals ...string) []string {
+	out := []string{}
+	for _, v := range vals {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// importGoogleCSV implements `import --format google-csv FILE`.
+func importGoogleCSV(path string, apply bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	defer f.Close()
+	cards, sources, err := readGoogleCSV(f)
+	if err != nil {
+		log.Fatalf("import: %s: %v", path, err)
+	}
+	client := newClient()
+	applyImport("import", client, planImport(mustFetch(client), cards, sources), apply)
+}
+
+// exportContacts implements `export --format google-csv|jcard|xcard`, sorted by display name.
+func exportContacts(format, out string) {
+	cards := []vcard.Card{}
+	for _, cd := range mustFetch(newClient()) {
+		cards = append(cards, cd.Card)
+	}
+	sort.SliceStable(cards, func(i, j int) bool {
+		return norm(cards[i].Value(vcard.FieldFormattedName)) < norm(cards[j].Value(vcard.FieldFormattedName))
+	})
+	w := io.Writer(os.Stdout)
+	if out != "" && out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	var err error
+	switch format {
+	case "google-csv":
+		err = writeGoogleCSV(w, cards)
+	case "jcard", "xcard":
+		err = writeCardsAs(w, format, cards)
+	}
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	if out != "" && out != "-" {
+		log.Printf("export: %d contact(s) written to %s", len(cards), out)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// importAction is one planned step of an import: create a card, merge it into a server
+// card, or nothing when the server card already has everything.
+type importAction struct {
+	Kind   string // "create", "merge" or "same"
+	Source string // where the imported card came from (e.g. "row 12")
+	Card   vcard.Card
+	Target *cardData // server card for merge/same
+	before string    // Target as fetched, for the merge report
+}
+
+// planImport matches imported cards against the server by UID, or by a shared name, email
+// or phone as in review --duplicates. Cards that match an earlier imported card are folded
+// into it.
+func planImport(server []cardData, cards []vcard.Card, sources []string) []importAction {
+	owner := map[string]int{} // key -> action index
+	plan := []importAction{}
+	keysOf := func(card vcard.Card) []string {
+		keys := contactKeys(card)
+		if uid := strings.TrimSpace(card.Value(vcard.FieldUID)); uid != "" {
+			keys = append([]string{"uid:" + uid}, keys...)
+		}
+		if fn := norm(card.Value(vcard.FieldFormattedName)); fn != "" {
+			keys = append(keys, "fn:"+fn)
+		}
+		return keys
+	}
+	for i := range server {
+		plan = append(plan, importAction{Kind: "same", Target: &server[i], Card: copyCard(server[i].Card), before: encodeCard(server[i].Card)})
+		for _, k := range keysOf(server[i].Card) {
+			if _, ok := owner[k]; !ok {
+				owner[k] = len(plan) - 1
+			}
+		}
+	}
+	untouched := len(plan)
+	for n, card := range cards {
+		at := -1
+		for _, k := range keysOf(card) {
+			if i, ok := owner[k]; ok {
+				at = i
+				break
+			}
+		}
+		if at < 0 {
+			plan = append(plan, importAction{Kind: "create", Source: sources[n], Card: copyCard(card)})
+			at = len(plan) - 1
+		} else {
+			a := &plan[at]
+			before := encodeCard(a.Card)
+			mergeCards(&a.Card, card)
+			if a.Kind == "same" && encodeCard(a.Card) != before {
+				a.Kind = "merge"
+			}
+			if a.Source == "" {
+				a.Source = sources[n]
+			} else if !strings.Contains(a.Source, sources[n]) {
+				a.Source += ", " + sources[n]
+			}
+		}
+		for _, k := range keysOf(card) {
+			if _, ok := owner[k]; !ok {
+				owner[k] = at
+			}
+		}
+	}
+	// server cards nothing matched are not part of the plan
+	out := []importAction{}
+	for i, a := range plan {
+		if i >= untouched || a.Source != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// applyImport prints the plan and, with apply, creates and updates the cards.
+func applyImport(op string, client *radClient, plan []importAction, apply bool) {
+	ctx := context.Background()
+	var created, merged, same, failed int
+	for _, a := range plan {
+		name := a.Card.Value(vcard.FieldFormattedName)
+		switch a.Kind {
+		case "same":
+			same++
+			fmt.Printf("same    %s (%s)\n", name, a.Source)
+			continue
+		case "merge":
+			fmt.Printf("merge   %s <- %s\n", name, a.Source)
+			had := map[string]bool{}
+			for _, l := range strings.Split(a.before, "\n") {
+				had[l] = true
+			}
+			for _, l := range strings.Split(encodeCard(a.Card), "\n") {
+				if !had[l] {
+					fmt.Printf("          + %s\n", strings.TrimRight(l, "\r"))
+				}
+			}
+		case "create":
+			if missing := missingRequired(a.Card, ""); len(missing) > 0 {
+				log.Printf("%s: skip %s (%s): rules require %s", op, name, a.Source, strings.Join(missing, ", "))
+				failed++
+				continue
+			}
+			fmt.Printf("create  %s (%s)\n", name, a.Source)
+		}
+		if apply {
+			var err error
+			if a.Kind == "create" {
+				ensureUID(&a.Card)
+				err = client.put(ctx, cardRef{Href: fmt.Sprintf("%s%s.vcf", client.collectionURL(), randomID())}, a.Card)
+			} else {
+				err = client.put(ctx, a.Target.Ref, a.Card)
+			}
+			if err != nil {
+				log.Printf("%s: %s: %v", op, name, err)
+				failed++
+				continue
+			}
+		}
+		if a.Kind == "create" {
+			created++
+		} else {
+			merged++
+		}
+	}
+	log.Printf("%s: %d created, %d merged into existing, %d already present, %d skipped/failed. apply=%v", op, created, merged, same, failed, apply)
+}
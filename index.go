@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// bucketIndexVersion changes whenever the index layout does; older files are rebuilt.
+const bucketIndexVersion = 1
+
+// bucketIndex caches the parsed bucket tree on local disk (BUCKET_INDEX, default under the
+// user cache dir) so commands only re-read bucket files whose mtime or size changed since
+// the last run. It is sealed like the buckets when a bucket passphrase is set.
+type bucketIndex struct {
+	Version int                   `json:"version"`
+	Root    string                `json:"root"`
+	Files   map[string]*indexFile `json:"files"` // bucket path -> cached content
+
+	path  string
+	dirty bool
+	keys  map[string][]indexRef // "fn:…", "tel:…", "email:…" -> cards
+}
+
+type indexFile struct {
+	Bucket  string    `json:"bucket"`
+	ModTime time.Time `json:"mtime"`
+	Size    int64     `json:"size"`
+	Cards   []string  `json:"cards"` // VCF text per card, in file order
+}
+
+// indexRef points at one card of an indexed file.
+type indexRef struct {
+	Path  string
+	Index int
+}
+
+var (
+	indexOnce sync.Once
+	indexImpl *bucketIndex
+)
+
+// bucketIndexPath is BUCKET_INDEX, or a per-store file in the user cache dir ("" if none).
+func bucketIndexPath(root string) string {
+	if p := os.Getenv("BUCKET_INDEX"); p != "" {
+		return p
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(dir, "dav-manager", "buckets-"+hex.EncodeToString(sum[:6])+".json")
+}
+
+// loadedBucketIndex returns the index once some command has loaded it, else nil.
+func loadedBucketIndex() *bucketIndex { return indexImpl }
+
+// bucketIndexFor loads the index and brings it up to date with the store.
+func bucketIndexFor(root string) *bucketIndex {
+	indexOnce.Do(func() {
+		idx := &bucketIndex{path: bucketIndexPath(root)}
+		if idx.path != "" {
+			if data, err := os.ReadFile(idx.path); err == nil {
+				// a plaintext index must not outlive switching encryption on
+				sealed := isEncrypted(data)
+				if data, err = openBucketData(data); err == nil {
+					_ = json.Unmarshal(data, idx)
+				}
+				idx.dirty = !sealed && encryptBuckets()
+			}
+		}
+		if idx.Version != bucketIndexVersion || idx.Root != root || idx.Files == nil {
+			*idx = bucketIndex{Version: bucketIndexVersion, Root: root, Files: map[string]*indexFile{}, path: idx.path, dirty: true}
+		}
+		indexImpl = idx
+	})
+	indexImpl.refresh()
+	return indexImpl
+}
+
+// refresh re-reads new or changed bucket files and forgets deleted ones.
+func (idx *bucketIndex) refresh() {
+	seen := map[string]bool{}
+	for _, bucket := range bucketNames(idx.Root) {
+		walkStore(filepath.Join(idx.Root, bucket), false, func(p string, e storeEntry) {
+			if strings.ToLower(filepath.Ext(p)) != ".vcf" {
+				return
+			}
+			seen[p] = true
+			if f, ok := idx.Files[p]; ok && f.ModTime.Equal(e.ModTime) && f.Size == e.Size && f.Bucket == bucket {
+				return
+			}
+			cards, err := readVCF(p)
+			if err != nil {
+				// not cached, so the next run tries again
+				log.Printf("[warn] %s: %v (%d card(s) read)", p, err, len(cards))
+				idx.Files[p] = &indexFile{Bucket: bucket, Cards: encodeEach(cards)}
+				idx.dirty = true
+				return
+			}
+			idx.Files[p] = &indexFile{Bucket: bucket, ModTime: e.ModTime, Size: e.Size, Cards: encodeEach(cards)}
+			idx.dirty = true
+		})
+	}
+	for p := range idx.Files {
+		if !seen[p] {
+			delete(idx.Files, p)
+			idx.dirty = true
+		}
+	}
+	idx.keys = nil
+	idx.save()
+}
+
+func encodeEach(cards []vcard.Card) []string {
+	out := make([]string, len(cards))
+	for i, c := range cards {
+		out[i] = encodeCard(c)
+	}
+	return out
+}
+
+// update records cards just written to path (from writeVCF).
+func (idx *bucketIndex) update(path string, cards []vcard.Card) {
+	s, rel := storeFor(path)
+	if s != bucketStore() {
+		return
+	}
+	bucket := strings.SplitN(rel, "/", 2)[0]
+	if len(cards) == 0 || strings.HasPrefix(bucket, ".") || strings.ToLower(filepath.Ext(path)) != ".vcf" {
+		if _, ok := idx.Files[path]; ok {
+			delete(idx.Files, path)
+			idx.dirty, idx.keys = true, nil
+		}
+		return
+	}
+	f := &indexFile{Bucket: bucket, Cards: encodeEach(cards)}
+	if e, err := s.Stat(rel); err == nil {
+		f.ModTime, f.Size = e.ModTime, e.Size
+	}
+	idx.Files[path] = f
+	idx.dirty, idx.keys = true, nil
+}
+
+// save writes the index if it changed; failures only cost speed next time.
+func (idx *bucketIndex) save() {
+	if !idx.dirty || idx.path == "" {
+		return
+	}
+	data, err := json.Marshal(idx)
+	if err == nil && encryptBuckets() {
+		data, err = sealBucketData(data)
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(idx.path), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(idx.path, data, 0o600)
+	}
+	if err != nil {
+		log.Printf("[warn] bucket index %s: %v", idx.path, err)
+		return
+	}
+	idx.dirty = false
+}
+
+// saveBucketIndex flushes updates made by writes during this command.
+func saveBucketIndex() {
+	if idx := loadedBucketIndex(); idx != nil {
+		idx.save()
+	}
+}
+
+// card decodes one cached card.
+func (idx *bucketIndex) card(ref indexRef) (vcard.Card, bool) {
+	f, ok := idx.Files[ref.Path]
+	if !ok || ref.Index >= len(f.Cards) {
+		return nil, false
+	}
+	c, err := vcard.NewDecoder(strings.NewReader(f.Cards[ref.Index])).Decode()
+	return c, err == nil
+}
+
+// cards returns every indexed card in bucket/file order.
+func (idx *bucketIndex) cards() []bucketCard {
+	paths := make([]string, 0, len(idx.Files))
+	for p := range idx.Files {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := idx.Files[paths[i]], idx.Files[paths[j]]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		return paths[i] < paths[j]
+	})
+	out := []bucketCard{}
+	for _, p := range paths {
+		for i := range idx.Files[p].Cards {
+			if c, ok := idx.card(indexRef{Path: p, Index: i}); ok {
+				out = append(out, bucketCard{Bucket: idx.Files[p].Bucket, Path: p, Index: i, Card: c})
+			}
+		}
+	}
+	return out
+}
+
+// lookup returns the cards carrying key: "fn:" + norm(name) (FN or X-DAV-ORIGINAL-FN),
+// or a contactKeys key ("tel:+91…", "email:…").
+func (idx *bucketIndex) lookup(key string) []bucketCard {
+	if idx.keys == nil {
+		idx.keys = map[string][]indexRef{}
+		for _, bc := range idx.cards() {
+			ref := indexRef{Path: bc.Path, Index: bc.Index}
+			keys := contactKeys(bc.Card)
+			for _, fn := range []string{bc.Card.Value(vcard.FieldFormattedName), bc.Card.Value(fieldOriginalFN)} {
+				if fn = norm(fn); fn != "" {
+					keys = append(keys, "fn:"+fn)
+				}
+			}
+			done := map[string]bool{}
+			for _, k := range keys {
+				if !done[k] {
+					done[k] = true
+					idx.keys[k] = append(idx.keys[k], ref)
+				}
+			}
+		}
+	}
+	out := []bucketCard{}
+	for _, ref := range idx.keys[key] {
+		if c, ok := idx.card(ref); ok {
+			out = append(out, bucketCard{Bucket: idx.Files[ref.Path].Bucket, Path: ref.Path, Index: ref.Index, Card: c})
+		}
+	}
+	return out
+}
+
+// reindexBuckets implements `buckets reindex`: drop the cache and rebuild it.
+func reindexBuckets() {
+	root := bucketRoot()
+	if p := bucketIndexPath(root); p != "" {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("buckets reindex: %v", err)
+		}
+	}
+	idx := bucketIndexFor(root)
+	n := 0
+	for _, f := range idx.Files {
+		n += len(f.Cards)
+	}
+	log.Printf("buckets reindex: %d file(s), %d card(s) indexed at %s", len(idx.Files), n, idx.path)
+}
@@ -0,0 +1,134 @@
+// Package email validates and normalizes e-mail addresses, including internationalized
+// domain names, and derives canonical keys that see through provider aliases
+// (Gmail dots, "+tag" sub-addressing) for duplicate detection.
+package email
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// Errors returned by Normalize.
+var (
+	ErrEmpty      = errors.New("empty address")
+	ErrNoAt       = errors.New("missing @")
+	ErrLocalPart  = errors.New("invalid local part")
+	ErrDomain     = errors.New("invalid domain")
+	ErrTooLong    = errors.New("address too long")
+	ErrNoTLD      = errors.New("domain has no top-level domain")
+	ErrWhitespace = errors.New("contains whitespace")
+)
+
+var lookup = idna.New(idna.MapForLookup(), idna.StrictDomainName(true), idna.Transitional(false))
+
+// Normalize trims, strips "mailto:", lowercases and validates addr. Internationalized
+// domains are validated via their punycode form and returned in Unicode.
+func Normalize(addr string) (string, error) {
+	s := strings.TrimSpace(addr)
+	if strings.HasPrefix(strings.ToLower(s), "mailto:") {
+		s = s[len("mailto:"):]
+	}
+	s = strings.Trim(s, "<>")
+	if s == "" {
+		return "", ErrEmpty
+	}
+	if strings.IndexFunc(s, unicode.IsSpace) >= 0 {
+		return "", ErrWhitespace
+	}
+	at := strings.LastIndex(s, "@")
+	if at < 0 {
+		return "", ErrNoAt
+	}
+	local, domain := strings.ToLower(s[:at]), s[at+1:]
+	if err := checkLocal(local); err != nil {
+		return "", err
+	}
+	ascii, err := lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDomain, err)
+	}
+	if !strings.Contains(ascii, ".") {
+		return "", ErrNoTLD
+	}
+	tld := ascii[strings.LastIndex(ascii, ".")+1:]
+	if !strings.HasPrefix(tld, "xn--") && strings.IndexFunc(tld, func(r rune) bool { return r < 'a' || r > 'z' }) >= 0 {
+		return "", fmt.Errorf("%w: top-level domain %q", ErrDomain, tld)
+	}
+	if len(local)+1+len(ascii) > 254 {
+		return "", ErrTooLong
+	}
+	uni, err := idna.ToUnicode(ascii)
+	if err != nil {
+		uni = ascii
+	}
+	return local + "@" + uni, nil
+}
+
+// checkLocal accepts dot-atom local parts (RFC 5322) plus UTF-8 letters (RFC 6531).
+func checkLocal(local string) error {
+	if local == "" || len(local) > 64 {
+		return ErrLocalPart
+	}
+	if strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, "..") {
+		return ErrLocalPart
+	}
+	for _, r := range local {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case strings.ContainsRune("!#$%&'*+-/=?^_`{|}~.", r):
+		case r > unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)):
+		default:
+			return fmt.Errorf("%w: %q not allowed", ErrLocalPart, r)
+		}
+	}
+	return nil
+}
+
+// providers maps domains to their alias rules.
+var providers = map[string]struct {
+	canonical  string
+	dropDots   bool
+	plusSuffix bool
+}{
+	"gmail.com":      {"gmail.com", true, true},
+	"googlemail.com": {"gmail.com", true, true},
+	"outlook.com":    {"outlook.com", false, true},
+	"hotmail.com":    {"hotmail.com", false, true},
+	"live.com":       {"live.com", false, true},
+	"icloud.com":     {"icloud.com", false, true},
+	"me.com":         {"icloud.com", false, true},
+	"mac.com":        {"icloud.com", false, true},
+	"fastmail.com":   {"fastmail.com", false, true},
+	"proton.me":      {"proton.me", false, true},
+	"protonmail.com": {"proton.me", false, true},
+	"pm.me":          {"proton.me", false, true},
+}
+
+// Canonical returns a key for duplicate detection: the normalized address with provider
+// aliases folded (e.g. "J.Doe+news@googlemail.com" -> "jdoe@gmail.com"). Unknown
+// providers only get normalized. Invalid addresses fall back to a trimmed lowercase form.
+func Canonical(addr string) string {
+	n, err := Normalize(addr)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(addr))
+	}
+	at := strings.LastIndex(n, "@")
+	local, domain := n[:at], n[at+1:]
+	p, ok := providers[domain]
+	if !ok {
+		return n
+	}
+	if p.plusSuffix {
+		if i := strings.Index(local, "+"); i > 0 {
+			local = local[:i]
+		}
+	}
+	if p.dropDots {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + p.canonical
+}
@@ -0,0 +1,163 @@
+package names
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Options adjusts Display's casing exceptions.
+type Options struct {
+	LowercaseWords []string // extra words kept lowercase inside a name, like "van"
+	KeepCase       []string // words always written exactly like this ("iPhone", "DJ")
+}
+
+// Display returns the display name cleaned up for storage: Unicode NFC, emoji and
+// invisible characters removed, whitespace collapsed, leading honorifics stripped (and
+// returned separately) and Title Case with the usual exceptions (McX, O'X, lowercase
+// particles, all-caps acronyms, mixed-case words kept as written). Email-like names
+// are only lowercased.
+func Display(fn string) (string, []string) { return DisplayWith(fn, Options{}) }
+
+// DisplayWith is Display with extra casing exceptions.
+func DisplayWith(fn string, opt Options) (string, []string) {
+	s := strings.Join(strings.Fields(Clean(fn)), " ")
+	if s == "" {
+		return "", nil
+	}
+	if !strings.Contains(s, " ") && strings.Contains(s, "@") {
+		return strings.ToLower(s), nil
+	}
+	toks := strings.Fields(s)
+	honorifics := []string{}
+	for len(toks) > 1 && isPrefix(toks) {
+		honorifics = append(honorifics, toks[0])
+		toks = toks[1:]
+	}
+	// An all-caps name is shouting, not a row of acronyms; suffixes ("phd") don't count.
+	shouting := true
+	for _, t := range toks {
+		if !suffixes[key(t)] && hasLower(t) {
+			shouting = false
+		}
+	}
+	keep := map[string]string{}
+	for _, w := range opt.KeepCase {
+		keep[strings.ToLower(w)] = w
+	}
+	lower := map[string]bool{}
+	for _, w := range opt.LowercaseWords {
+		lower[strings.ToLower(w)] = true
+	}
+	for i, t := range toks {
+		if w, ok := keep[strings.ToLower(t)]; ok {
+			toks[i] = w
+			continue
+		}
+		// "MS." left in the name is initials; title-casing it would make it an honorific
+		if i == 0 && ambiguousPrefixes[key(t)] && strings.HasSuffix(t, ".") {
+			toks[i] = t
+			continue
+		}
+		// a particle is only lowercase inside the name: "Rahul Das", "Kim Le" stay capitalized
+		inner := i > 0 && i < len(toks)-1
+		if inner && lower[strings.ToLower(t)] {
+			toks[i] = strings.ToLower(t)
+			continue
+		}
+		toks[i] = titleWord(t, i == 0, inner, shouting)
+	}
+	return strings.Join(toks, " "), honorifics
+}
+
+// Clean applies NFC and drops emoji, pictographs and invisible formatting characters.
+// Zero-width (non-)joiners are kept between letters, where scripts such as Devanagari
+// and Persian need them.
+func Clean(s string) string {
+	rs := []rune(norm.NFC.String(s))
+	out := make([]rune, 0, len(rs))
+	for i, r := range rs {
+		switch {
+		case r == '‌' || r == '‍':
+			if i > 0 && i < len(rs)-1 && isLetter(rs[i-1]) && isLetter(rs[i+1]) {
+				out = append(out, r)
+			}
+		case r >= 0xfe00 && r <= 0xfe0f, r == 0x20e3, r >= 0x1f3fb && r <= 0x1f3ff:
+			// variation selectors, keycap, skin tones
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Cf, r), unicode.Is(unicode.Co, r), unicode.Is(unicode.Cs, r):
+		case unicode.IsControl(r):
+			out = append(out, ' ')
+		default:
+			out = append(out, r)
+		}
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func isLetter(r rune) bool { return unicode.IsLetter(r) || unicode.IsMark(r) }
+
+func hasLower(s string) bool {
+	return strings.IndexFunc(s, unicode.IsLower) >= 0
+}
+
+// displayParticles are written lowercase between given and family name ("Ludwig van
+// Beethoven"). Unlike Parse's particles it leaves out words that are common surnames or
+// name parts on their own ("Das", "Le", "Al", "St").
+var displayParticles = map[string]bool{
+	"van": true, "von": true, "de": true, "der": true, "den": true, "del": true, "della": true,
+	"di": true, "da": true, "du": true, "dos": true, "ter": true, "ten": true, "bin": true, "binti": true,
+}
+
+func titleWord(w string, first, inner, shouting bool) string {
+	k := key(w)
+	switch {
+	case suffixes[k] && !first:
+		if strings.HasSuffix(w, ",") {
+			return canonicalSuffix(w) + ","
+		}
+		return canonicalSuffix(w)
+	case inner && displayParticles[strings.ToLower(w)]:
+		return strings.ToLower(w)
+	case !shouting && !hasLower(w) && len([]rune(strings.Trim(w, ".,()"))) > 1:
+		return w // acronym: "IBM", "HDFC"
+	case hasLower(w) && strings.IndexFunc(string([]rune(w)[1:]), unicode.IsUpper) >= 0:
+		return w // deliberate mixed case: "DeShawn", "McDonald", "iPhone"
+	}
+	// Title-case each hyphen/apostrophe-separated part: "Jean-Luc", "O'Brien".
+	var b strings.Builder
+	start := true
+	for _, r := range strings.ToLower(w) {
+		if start && unicode.IsLetter(r) {
+			b.WriteRune(unicode.ToTitle(r))
+			start = false
+			continue
+		}
+		b.WriteRune(r)
+		if r == '-' || r == '\'' || r == '’' || r == '(' {
+			start = true
+		}
+	}
+	out := b.String()
+	// "Mcdonald" -> "McDonald"
+	if rs := []rune(out); len(rs) > 3 && strings.HasPrefix(out, "Mc") && unicode.IsLetter(rs[2]) {
+		rs[2] = unicode.ToUpper(rs[2])
+		out = string(rs)
+	}
+	return out
+}
+
+// canonicalSuffix writes generational/professional suffixes the usual way ("Jr.", "III", "PhD").
+func canonicalSuffix(w string) string {
+	switch key(w) {
+	case "jr":
+		return "Jr."
+	case "sr":
+		return "Sr."
+	case "phd":
+		return "PhD"
+	case "esq":
+		return "Esq."
+	}
+	return strings.ToUpper(strings.TrimSuffix(w, ","))
+}
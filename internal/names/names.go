@@ -0,0 +1,282 @@
+// Package names splits display names (vCard FN) into structured name components
+// (vCard N: family;given;additional;prefix;suffix).
+package names
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Name holds the five N components.
+type Name struct {
+	Family     string
+	Given      string
+	Additional string
+	Prefix     string
+	Suffix     string
+}
+
+// ErrOverride is returned by ParseOverride for malformed override strings.
+var ErrOverride = errors.New("override must be family;given[;additional[;prefix[;suffix]]]")
+
+// honorific prefixes, lowercased without the trailing dot.
+var prefixes = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "miss": true, "mx": true, "dr": true, "prof": true,
+	"sir": true, "dame": true, "lord": true, "lady": true, "rev": true, "fr": true, "hon": true,
+	"capt": true, "col": true, "gen": true, "lt": true, "sgt": true, "maj": true,
+	"shri": true, "sri": true, "smt": true, "kumari": true, "km": true,
+}
+
+// ambiguous honorifics double as given names or initials ("MS Dhoni", "Sri Harsha"): they
+// only count written with a dot ("Ms.", "Sri.") or with at least two more words after them.
+// Anything else is left to the name overrides.
+var ambiguousPrefixes = map[string]bool{"ms": true, "sri": true}
+
+// isPrefix reports whether toks[0] is an honorific prefix of the name toks.
+func isPrefix(toks []string) bool {
+	k := key(toks[0])
+	if !prefixes[k] {
+		return false
+	}
+	if !ambiguousPrefixes[k] {
+		return true
+	}
+	t := strings.TrimSuffix(toks[0], ",")
+	// "MS." in capitals is initials
+	dotted := strings.HasSuffix(t, ".") && hasLower(t)
+	return dotted || len(toks) > 2
+}
+
+// generational and professional suffixes, lowercased without dots.
+var suffixes = map[string]bool{
+	"jr": true, "sr": true, "ii": true, "iii": true, "iv": true, "v": true,
+	"phd": true, "md": true, "dds": true, "esq": true, "cpa": true, "mba": true, "jd": true,
+	"rn": true, "obe": true, "mbe": true, "cbe": true, "kc": true, "qc": true,
+}
+
+// particles start a family name when they follow the given name ("van", "de la", "bin").
+var particles = map[string]bool{
+	"van": true, "von": true, "de": true, "der": true, "den": true, "del": true, "della": true,
+	"di": true, "da": true, "du": true, "dos": true, "das": true, "la": true, "le": true,
+	"ter": true, "ten": true, "bin": true, "binti": true, "al": true, "el": true, "st": true,
+}
+
+func key(tok string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSuffix(tok, ","), ".", ""))
+}
+
+// Parse splits a display name. It understands honorific prefixes ("Dr."), suffixes
+// ("Jr.", "PhD", also after a comma), "Family, Given" order, family-name particles
+// ("Ludwig van Beethoven"), single-word names (given only), a trailing parenthetical
+// qualifier ("Vendor X (2019)", ignored) and addresses used as names
+// ("jane.doe@example.com" -> Doe;Jane).
+func Parse(fn string) Name {
+	s := strings.Join(strings.Fields(fn), " ")
+	s = stripQualifier(s)
+	if s == "" {
+		return Name{}
+	}
+	if !strings.Contains(s, " ") && strings.Contains(s, "@") {
+		return fromEmail(s)
+	}
+
+	var n Name
+	// "Doe, Jane" / "Jane Doe, Jr." / "Doe, Jane, PhD"
+	if parts := strings.Split(s, ","); len(parts) > 1 {
+		head := strings.TrimSpace(parts[0])
+		rest := []string{}
+		sfx := []string{}
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if allSuffixes(p) {
+				sfx = append(sfx, p)
+			} else {
+				rest = append(rest, p)
+			}
+		}
+		n.Suffix = strings.Join(sfx, ", ")
+		if len(rest) == 0 {
+			s = head
+		} else {
+			// Family first; the given part may still carry prefixes/suffixes.
+			given := parseTokens(strings.Fields(strings.Join(rest, " ")), false)
+			n.Family = head
+			n.Given = strings.TrimSpace(given.Given + " " + given.Family)
+			n.Additional = given.Additional
+			n.Prefix = given.Prefix
+			n.Suffix = joinNonEmpty(", ", given.Suffix, n.Suffix)
+			return n
+		}
+	}
+	t := parseTokens(strings.Fields(s), true)
+	t.Suffix = joinNonEmpty(", ", t.Suffix, n.Suffix)
+	return t
+}
+
+// parseTokens handles the "Prefix Given Additional Family Suffix" order. With particles
+// false, the last token is not split off as a family name.
+func parseTokens(toks []string, particlesOK bool) Name {
+	var n Name
+	pre := []string{}
+	for len(toks) > 1 && isPrefix(toks) {
+		pre = append(pre, toks[0])
+		toks = toks[1:]
+	}
+	sfx := []string{}
+	for len(toks) > 1 && suffixes[key(toks[len(toks)-1])] {
+		sfx = append([]string{strings.TrimSuffix(toks[len(toks)-1], ",")}, sfx...)
+		toks = toks[:len(toks)-1]
+	}
+	n.Prefix = strings.Join(pre, " ")
+	n.Suffix = strings.Join(sfx, " ")
+	switch {
+	case len(toks) == 0:
+		return n
+	case len(toks) == 1:
+		// Single word: a given name ("Madonna") unless only a title preceded it ("Dr. Rao").
+		if len(pre) > 0 && particlesOK {
+			n.Family = toks[0]
+		} else {
+			n.Given = toks[0]
+		}
+		return n
+	case !particlesOK:
+		n.Given = toks[0]
+		n.Additional = strings.Join(toks[1:], " ")
+		return n
+	}
+	n.Given = toks[0]
+	fam := len(toks) - 1
+	for i := 1; i < len(toks)-1; i++ {
+		if particles[strings.ToLower(toks[i])] {
+			fam = i
+			break
+		}
+	}
+	n.Additional = strings.Join(toks[1:fam], " ")
+	n.Family = strings.Join(toks[fam:], " ")
+	return n
+}
+
+func allSuffixes(s string) bool {
+	for _, t := range strings.Fields(s) {
+		if !suffixes[key(t)] {
+			return false
+		}
+	}
+	return true
+}
+
+// stripQualifier drops a trailing "(...)" note such as "(2019)" or "(Work)".
+func stripQualifier(s string) string {
+	if strings.HasSuffix(s, ")") {
+		if i := strings.LastIndex(s, " ("); i > 0 {
+			return strings.TrimSpace(s[:i])
+		}
+	}
+	return s
+}
+
+func fromEmail(addr string) Name {
+	local := addr[:strings.Index(addr, "@")]
+	if i := strings.Index(local, "+"); i > 0 {
+		local = local[:i]
+	}
+	parts := strings.FieldsFunc(local, func(r rune) bool { return r == '.' || r == '_' || r == '-' })
+	for i, p := range parts {
+		parts[i] = capitalize(p)
+	}
+	switch len(parts) {
+	case 0:
+		return Name{Given: addr}
+	case 1:
+		return Name{Given: parts[0]}
+	}
+	return Name{Given: parts[0], Additional: strings.Join(parts[1:len(parts)-1], " "), Family: parts[len(parts)-1]}
+}
+
+func capitalize(s string) string {
+	r := []rune(strings.ToLower(s))
+	if len(r) > 0 && !unicode.IsDigit(r[0]) {
+		r[0] = unicode.ToUpper(r[0])
+	}
+	return string(r)
+}
+
+func joinNonEmpty(sep string, parts ...string) string {
+	out := []string{}
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, sep)
+}
+
+// ParseOverride reads an explicit "family;given;additional;prefix;suffix" string; trailing
+// components may be omitted ("García Márquez;Gabriel").
+func ParseOverride(s string) (Name, error) {
+	parts := strings.Split(s, ";")
+	if len(parts) < 2 || len(parts) > 5 {
+		return Name{}, fmt.Errorf("%w: %q", ErrOverride, s)
+	}
+	for len(parts) < 5 {
+		parts = append(parts, "")
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	n := Name{Family: parts[0], Given: parts[1], Additional: parts[2], Prefix: parts[3], Suffix: parts[4]}
+	if n.Family == "" && n.Given == "" {
+		return Name{}, fmt.Errorf("%w: %q", ErrOverride, s)
+	}
+	return n, nil
+}
+
+// String returns the N value, e.g. "van Beethoven;Ludwig;;;".
+func (n Name) String() string {
+	return strings.Join([]string{n.Family, n.Given, n.Additional, n.Prefix, n.Suffix}, ";")
+}
+
+// Components reports how many of the five components are set.
+func (n Name) Components() int {
+	c := 0
+	for _, p := range []string{n.Family, n.Given, n.Additional, n.Prefix, n.Suffix} {
+		if p != "" {
+			c++
+		}
+	}
+	return c
+}
+
+// Matches reports whether n uses the same words as fn, ignoring order, case, punctuation
+// and a trailing parenthetical qualifier; i.e. whether N is still in sync with FN.
+func (n Name) Matches(fn string) bool {
+	a := words(strings.Join([]string{n.Prefix, n.Given, n.Additional, n.Family, n.Suffix}, " "))
+	b := words(stripQualifier(strings.Join(strings.Fields(fn), " ")))
+	if len(b) == 0 || len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, w := range a {
+		counts[w]++
+	}
+	for _, w := range b {
+		if counts[w] == 0 {
+			return false
+		}
+		counts[w]--
+	}
+	return true
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+	})
+}
@@ -0,0 +1,381 @@
+// Package phone parses, validates and formats telephone numbers using an embedded
+// numbering-plan table (calling codes, trunk/international prefixes, national number
+// lengths, mobile/fixed ranges and national grouping formats).
+package phone
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//go:embed metadata.json
+var metadataJSON []byte
+
+// Errors returned by Parse for numbers that cannot exist.
+var (
+	ErrEmpty          = errors.New("no digits")
+	ErrUnknownCountry = errors.New("unknown country calling code")
+	ErrTooShort       = errors.New("too short for its numbering plan")
+	ErrTooLong        = errors.New("too long for its numbering plan")
+	ErrInvalid        = errors.New("not a valid number range for its numbering plan")
+	ErrUnknownRegion  = errors.New("unknown region")
+)
+
+// Type classifies a number by the range it falls in.
+type Type string
+
+const (
+	Unknown  Type = "unknown"
+	Mobile   Type = "mobile"
+	Fixed    Type = "fixed"
+	TollFree Type = "tollfree"
+)
+
+// Number is a parsed telephone number.
+type Number struct {
+	Region      string // ISO 3166 region whose numbering plan matched
+	CountryCode string // calling code without "+"
+	National    string // national significant number (no trunk prefix)
+	Extension   string
+}
+
+type format struct {
+	Leading string `json:"leading"`
+	Groups  []int  `json:"groups"` // 0 takes whatever digits remain
+
+	leading *regexp.Regexp
+}
+
+// Meta is the numbering-plan metadata for one region.
+type Meta struct {
+	Region      string   `json:"region"`
+	CallingCode string   `json:"cc"`
+	Main        bool     `json:"main"` // main region for a shared calling code
+	Trunk       string   `json:"trunk"`
+	IntlPrefix  string   `json:"intl"`
+	Lengths     []int    `json:"lengths"`
+	General     string   `json:"general"`
+	MobileRe    string   `json:"mobile"`
+	FixedRe     string   `json:"fixed"`
+	TollFreeRe  string   `json:"tollfree"`
+	Formats     []format `json:"formats"`
+
+	general, mobile, fixed, tollFree *regexp.Regexp
+}
+
+var (
+	byRegion = map[string]*Meta{}
+	byCode   = map[string][]*Meta{} // main region last
+)
+
+func init() {
+	var metas []*Meta
+	if err := json.Unmarshal(metadataJSON, &metas); err != nil {
+		panic(fmt.Sprintf("phone: bad metadata: %v", err))
+	}
+	for _, m := range metas {
+		if m.IntlPrefix == "" {
+			m.IntlPrefix = "00"
+		}
+		m.general = compileFull(m.General)
+		m.mobile = compileFull(m.MobileRe)
+		m.fixed = compileFull(m.FixedRe)
+		m.tollFree = compileFull(m.TollFreeRe)
+		for i := range m.Formats {
+			if m.Formats[i].Leading != "" {
+				m.Formats[i].leading = regexp.MustCompile("^(?:" + m.Formats[i].Leading + ")")
+			}
+		}
+		byRegion[m.Region] = m
+		byCode[m.CallingCode] = append(byCode[m.CallingCode], m)
+	}
+	// Regions sharing a calling code have narrower ranges than the main one; try them first.
+	for _, metas := range byCode {
+		sort.SliceStable(metas, func(i, j int) bool { return !metas[i].Main && metas[j].Main })
+	}
+}
+
+func compileFull(expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+	return regexp.MustCompile("^(?:" + expr + ")$")
+}
+
+// Lookup returns the metadata for a region code such as "IN" or "gb".
+func Lookup(region string) (*Meta, bool) {
+	m, ok := byRegion[strings.ToUpper(strings.TrimSpace(region))]
+	return m, ok
+}
+
+// IsRegion reports whether region has numbering-plan metadata.
+func IsRegion(region string) bool {
+	_, ok := Lookup(region)
+	return ok
+}
+
+// Regions returns all known region codes, sorted.
+func Regions() []string {
+	out := make([]string, 0, len(byRegion))
+	for k := range byRegion {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CallingCode returns the calling code for region ("" if unknown).
+func CallingCode(region string) string {
+	if m, ok := Lookup(region); ok {
+		return m.CallingCode
+	}
+	return ""
+}
+
+// Parse interprets raw as an international number ("+44 7700 900123", "0044...") or as a
+// number dialled nationally within defaultRegion ("07700 900123"). It returns an error for
+// numbers that are impossible under the matching numbering plan.
+func Parse(raw, defaultRegion string) (Number, error) {
+	home, ok := Lookup(defaultRegion)
+	if !ok {
+		return Number{}, fmt.Errorf("%w %q", ErrUnknownRegion, defaultRegion)
+	}
+	body, ext := splitExtension(raw)
+	plus := strings.HasPrefix(strings.TrimLeft(body, " \t( "), "+")
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, body)
+	if digits == "" {
+		return Number{}, ErrEmpty
+	}
+	var n Number
+	var err error
+	switch {
+	case plus && !strings.HasPrefix(digits, "0"):
+		n, err = parseInternational(digits)
+	case plus:
+		// "+0..." is a national number someone prefixed with "+"; no calling code starts with 0.
+		n, err = parseNational(digits, home)
+	default:
+		n, err = parseNational(digits, home)
+		if err != nil && strings.HasPrefix(digits, home.IntlPrefix) {
+			if in, ierr := parseInternational(digits[len(home.IntlPrefix):]); ierr == nil {
+				n, err = in, nil
+			}
+		}
+		if err != nil {
+			// international number written without "+" (e.g. 447700900123)
+			if in, ierr := parseInternational(digits); ierr == nil {
+				n, err = in, nil
+			}
+		}
+	}
+	if err != nil {
+		return Number{}, err
+	}
+	n.Extension = ext
+	return n, nil
+}
+
+func parseNational(digits string, m *Meta) (Number, error) {
+	if m.Trunk != "" && strings.HasPrefix(digits, m.Trunk) {
+		if rest := digits[len(m.Trunk):]; m.check(rest) == nil {
+			return Number{Region: m.Region, CountryCode: m.CallingCode, National: rest}, nil
+		}
+	}
+	if err := m.check(digits); err != nil {
+		return Number{}, err
+	}
+	return Number{Region: m.Region, CountryCode: m.CallingCode, National: digits}, nil
+}
+
+func parseInternational(digits string) (Number, error) {
+	for l := 1; l <= 3 && l < len(digits); l++ {
+		metas, ok := byCode[digits[:l]]
+		if !ok {
+			continue
+		}
+		national := digits[l:]
+		var lastErr error
+		for _, m := range metas {
+			nat := national
+			// "+44 (0) 20 ..." style: drop a trunk prefix written after the calling code.
+			if m.Trunk != "" && strings.HasPrefix(nat, m.Trunk) && m.check(nat) != nil && m.check(nat[len(m.Trunk):]) == nil {
+				nat = nat[len(m.Trunk):]
+			}
+			err := m.check(nat)
+			if err == nil {
+				return Number{Region: m.Region, CountryCode: m.CallingCode, National: nat}, nil
+			}
+			lastErr = err
+		}
+		return Number{}, lastErr
+	}
+	return Number{}, ErrUnknownCountry
+}
+
+// check validates a national significant number against the plan.
+func (m *Meta) check(national string) error {
+	if len(m.Lengths) > 0 {
+		min, max := m.Lengths[0], m.Lengths[len(m.Lengths)-1]
+		switch {
+		case len(national) < min:
+			return ErrTooShort
+		case len(national) > max:
+			return ErrTooLong
+		}
+		found := false
+		for _, l := range m.Lengths {
+			if l == len(national) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrInvalid
+		}
+	}
+	if m.general != nil && !m.general.MatchString(national) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func splitExtension(raw string) (string, string) {
+	lower := strings.ToLower(raw)
+	for _, marker := range []string{";ext=", "ext.", "ext", "x", "#"} {
+		if i := strings.Index(lower, marker); i > 0 {
+			ext := strings.Map(func(r rune) rune {
+				if r >= '0' && r <= '9' {
+					return r
+				}
+				return -1
+			}, raw[i+len(marker):])
+			return raw[:i], ext
+		}
+	}
+	return raw, ""
+}
+
+// Type reports whether the number is mobile, fixed-line or toll-free when the plan says so.
+func (n Number) Type() Type {
+	m, ok := byRegion[n.Region]
+	if !ok {
+		return Unknown
+	}
+	switch {
+	case m.tollFree != nil && m.tollFree.MatchString(n.National):
+		return TollFree
+	case m.mobile != nil && m.mobile.MatchString(n.National):
+		return Mobile
+	case m.fixed != nil && m.fixed.MatchString(n.National):
+		return Fixed
+	}
+	return Unknown
+}
+
+// E164 returns the compact form, e.g. "+447700900123".
+func (n Number) E164() string {
+	return "+" + n.CountryCode + n.National
+}
+
+// Format returns the international form grouped per the national plan,
+// e.g. "+44 7700 900123", "+91 98765 43210", "+1 650 555 0123".
+func (n Number) Format() string {
+	s := "+" + n.CountryCode
+	if groups := groupNational(n.Region, n.National); len(groups) > 0 {
+		s += " " + strings.Join(groups, " ")
+	}
+	if n.Extension != "" {
+		s += " ext. " + n.Extension
+	}
+	return s
+}
+
+func groupNational(region, national string) []string {
+	if m, ok := byRegion[region]; ok {
+		for _, f := range m.Formats {
+			if f.leading != nil && !f.leading.MatchString(national) {
+				continue
+			}
+			if g, ok := applyGroups(national, f.Groups); ok {
+				return g
+			}
+		}
+	}
+	return applyDefault(national)
+}
+
+func applyGroups(s string, sizes []int) ([]string, bool) {
+	fixed, rest := 0, -1
+	for i, sz := range sizes {
+		if sz == 0 {
+			if rest >= 0 {
+				return nil, false
+			}
+			rest = i
+			continue
+		}
+		fixed += sz
+	}
+	sizes = append([]int(nil), sizes...)
+	if rest >= 0 {
+		if len(s) <= fixed {
+			return nil, false
+		}
+		sizes[rest] = len(s) - fixed
+	} else if fixed != len(s) {
+		return nil, false
+	}
+	out := []string{}
+	for _, sz := range sizes {
+		out = append(out, s[:sz])
+		s = s[sz:]
+	}
+	return out, true
+}
+
+func applyDefault(s string) []string {
+	var sizes []int
+	switch len(s) {
+	case 0:
+		return nil
+	case 1, 2, 3, 4:
+		return []string{s}
+	case 5:
+		sizes = []int{2, 3}
+	case 6:
+		sizes = []int{3, 3}
+	case 7:
+		sizes = []int{3, 4}
+	case 8:
+		sizes = []int{4, 4}
+	case 9:
+		sizes = []int{3, 3, 3}
+	case 10:
+		sizes = []int{3, 3, 4}
+	case 11:
+		sizes = []int{3, 4, 4}
+	default:
+		sizes = []int{4, 4, 0}
+	}
+	g, _ := applyGroups(s, sizes)
+	return g
+}
+
+// Normalize parses raw and returns its formatted international form.
+func Normalize(raw, defaultRegion string) (string, error) {
+	n, err := Parse(raw, defaultRegion)
+	if err != nil {
+		return "", err
+	}
+	return n.Format(), nil
+}
@@ -0,0 +1,69 @@
+package phone
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		raw, home string
+		region    string
+		e164      string
+	}{
+		// 07700 900xxx is the Ofcom drama range, not Jersey's 77003/77007/77008.
+		{"+447700900123", "US", "GB", "+447700900123"},
+		{"+44 7700 300123", "US", "JE", "+447700300123"},
+		{"+44 1534 123456", "US", "JE", "+441534123456"},
+		{"+44 7911 523456", "US", "GB", "+447911523456"},
+		{"+44 7911 723456", "US", "GG", "+447911723456"},
+		{"+44 7624 123456", "US", "IM", "+447624123456"},
+		{"07700 900123", "GB", "GB", "+447700900123"},
+		{"0044 20 7946 0018", "IN", "GB", "+442079460018"},
+		{"+1 416 555 0123", "IN", "CA", "+14165550123"},
+		{"+1 650 555 0123", "IN", "US", "+16505550123"},
+		{"98765 43210", "IN", "IN", "+919876543210"},
+		{"919876543210", "US", "IN", "+919876543210"},
+	}
+	for _, tt := range tests {
+		n, err := Parse(tt.raw, tt.home)
+		if err != nil {
+			t.Errorf("Parse(%q, %s): %v", tt.raw, tt.home, err)
+			continue
+		}
+		if n.Region != tt.region || n.E164() != tt.e164 {
+			t.Errorf("Parse(%q, %s) = %s %s, want %s %s", tt.raw, tt.home, n.Region, n.E164(), tt.region, tt.e164)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		raw, home string
+		want      error
+	}{
+		{"", "GB", ErrEmpty},
+		{"+44 20 7946", "US", ErrTooShort},
+		{"+999 123456", "US", ErrUnknownCountry},
+		{"12345", "XX", ErrUnknownRegion},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.raw, tt.home); !errors.Is(err, tt.want) {
+			t.Errorf("Parse(%q, %s) error = %v, want %v", tt.raw, tt.home, err, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct{ raw, home, want string }{
+		{"07700 900123", "GB", "+44 7700 900123"},
+		{"(650) 555-0123 ext. 42", "US", "+1 650 555 0123 ext. 42"},
+		{"+91 9876543210", "US", "+91 98765 43210"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.raw, tt.home)
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q, %s) = %q, %v; want %q", tt.raw, tt.home, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// cardValueTypes are the default value types of RFC 6350 properties that are not text.
+// jCard and xCard spell the type out on every property; VALUE= is only needed in vCard
+// when a property deviates from its default.
+var cardValueTypes = map[string]string{
+	vcard.FieldBirthday:           "date-and-or-time",
+	vcard.FieldAnniversary:        "date-and-or-time",
+	vcard.FieldRevision:           "timestamp",
+	vcard.FieldLanguage:           "language-tag",
+	vcard.FieldUID:                "uri",
+	vcard.FieldSource:             "uri",
+	vcard.FieldPhoto:              "uri",
+	vcard.FieldLogo:               "uri",
+	vcard.FieldSound:              "uri",
+	vcard.FieldURL:                "uri",
+	vcard.FieldIMPP:               "uri",
+	vcard.FieldGeolocation:        "uri",
+	vcard.FieldMember:             "uri",
+	vcard.FieldRelated:            "uri",
+	vcard.FieldKey:                "uri",
+	vcard.FieldFreeOrBusyURL:      "uri",
+	vcard.FieldCalendarURI:        "uri",
+	vcard.FieldCalendarAddressURI: "uri",
+}
+
+// cardComponents names the components of structured properties as xCard spells them; in
+// jCard the same properties carry an array value. ORG has any number of components.
+var cardComponents = map[string][]string{
+	vcard.FieldName:         {"surname", "given", "additional", "prefix", "suffix"},
+	vcard.FieldAddress:      {"pobox", "ext", "street", "locality", "region", "code", "country"},
+	vcard.FieldGender:       {"sex", "identity"},
+	vcard.FieldClientPIDMap: {"sourceid", "uri"},
+	vcard.FieldOrganization: nil,
+}
+
+// cardLists are text-list properties: go-vcard keeps them comma-joined in one value.
+var cardLists = []string{vcard.FieldCategories, vcard.FieldNickname}
+
+// propValueType returns the type a property is written with in jCard/xCard: its VALUE
+// parameter, else the RFC 6350 default, else text ("unknown" for X- properties).
+func propValueType(name string, f *vcard.Field) string {
+	if v := f.Params.Get(vcard.ParamValue); v != "" {
+		return strings.ToLower(v)
+	}
+	return defaultValueType(name)
+}
+
+func defaultValueType(name string) string {
+	if t, ok := cardValueTypes[name]; ok {
+		return t
+	}
+	if strings.HasPrefix(name, "X-") {
+		return "unknown"
+	}
+	return "text"
+}
+
+// isDateType reports value types whose values are dates or times.
+func isDateType(t string) bool {
+	switch t {
+	case "date", "time", "date-time", "date-and-or-time", "timestamp":
+		return true
+	}
+	return false
+}
+
+// sameValueType reports whether a parsed value type needs no VALUE parameter; any date
+// form satisfies a date-and-or-time default.
+func sameValueType(name, t string) bool {
+	def := defaultValueType(name)
+	return t == def || t == "unknown" || (def == "date-and-or-time" && isDateType(t))
+}
+
+// isStructured reports whether the field's value is split into components. N and ADR
+// always are; ORG, GENDER and CLIENTPIDMAP only when they have more than one.
+func isStructured(name string, f *vcard.Field) bool {
+	if _, ok := cardComponents[name]; !ok {
+		return false
+	}
+	return name == vcard.FieldName || name == vcard.FieldAddress || strings.Contains(f.Value, ";")
+}
+
+// extendedValue writes a 4.0 date or timestamp in the extended form jCard and xCard
+// require ("1985-04-12", "T10:22:00Z"); plainValue is the reverse.
+func extendedValue(s string) string {
+	s = extendedDate(s)
+	date, rest, ok := strings.Cut(s, "T")
+	if !ok || len(rest) < 6 || strings.Contains(rest, ":") {
+		return s
+	}
+	return date + "T" + rest[:2] + ":" + rest[2:4] + ":" + rest[4:]
+}
+
+func plainValue(s string) string {
+	date, rest, ok := strings.Cut(basicDate(s), "T")
+	if !ok {
+		return date
+	}
+	return date + "T" + strings.ReplaceAll(rest, ":", "")
+}
+
+// sortedFields lists the card's properties for jCard/xCard: VERSION first, the rest by name.
+func sortedFields(card vcard.Card) []string {
+	names := []string{}
+	for k := range card {
+		if k != vcard.FieldVersion {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{vcard.FieldVersion}, names...)
+}
+
+// asV4 returns a 4.0 copy of the card; jCard and xCard only exist for vCard 4.0.
+func asV4(card vcard.Card) vcard.Card {
+	card = copyCard(card)
+	convertCard(&card, "4.0")
+	return card
+}
+
+// encodeJCard writes cards as an RFC 7095 jCard array:
+// [["vcard", [["fn", {}, "text", "Jane Doe"], ...]], ...].
+func encodeJCard(cards []vcard.Card) ([]byte, error) {
+	out := []any{}
+	for _, card := range cards {
+		card = asV4(card)
+		props := []any{}
+		for _, name := range sortedFields(card) {
+			for _, f := range card[name] {
+				props = append(props, jcardProperty(name, f))
+			}
+		}
+		out = append(out, []any{"vcard", props})
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(out)
+	return b.Bytes(), err
+}
+
+func jcardProperty(name string, f *vcard.Field) []any {
+	typ := propValueType(name, f)
+	params := map[string]any{}
+	for k, vs := range f.Params {
+		if k == vcard.ParamValue || len(vs) == 0 {
+			continue
+		}
+		if len(vs) == 1 {
+			params[strings.ToLower(k)] = vs[0]
+		} else {
+			params[strings.ToLower(k)] = vs
+		}
+	}
+	if f.Group != "" {
+		params["group"] = f.Group
+	}
+	prop := []any{strings.ToLower(name), params, typ}
+	switch {
+	case isStructured(name, f):
+		parts := []any{}
+		for _, p := range strings.Split(f.Value, ";") {
+			parts = append(parts, p)
+		}
+		prop = append(prop, parts)
+	case containsString(cardLists, name):
+		for _, v := range strings.Split(f.Value, ",") {
+			prop = append(prop, v)
+		}
+	case isDateType(typ):
+		prop = append(prop, extendedValue(f.Value))
+	default:
+		prop = append(prop, f.Value)
+	}
+	return prop
+}
+
+// writeCardsAs writes cards as a jCard array or an xCard document (format "jcard" or "xcard").
+func writeCardsAs(w io.Writer, format string, cards []vcard.Card) error {
+	encode := encodeJCard
+	if format == "xcard" {
+		encode = encodeXCard
+	}
+	data, err := encode(cards)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// decodeJCard reads a single jCard or an array of them. Cards come back as vCard 4.0.
+func decodeJCard(data []byte) ([]vcard.Card, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc []any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("jcard: %w", err)
+	}
+	if len(doc) > 0 {
+		if s, ok := doc[0].(string); ok && s == "vcard" {
+			doc = []any{doc}
+		}
+	}
+	cards := []vcard.Card{}
+	for i, item := range doc {
+		jc, ok := item.([]any)
+		if !ok || len(jc) != 2 || jc[0] != "vcard" {
+			return cards, fmt.Errorf("jcard: card %d: not a [\"vcard\", [...]] array", i+1)
+		}
+		props, ok := jc[1].([]any)
+		if !ok {
+			return cards, fmt.Errorf("jcard: card %d: properties are not an array", i+1)
+		}
+		card := vcard.Card{}
+		for n, p := range props {
+			if err := addJCardProperty(card, p); err != nil {
+				return cards, fmt.Errorf("jcard: card %d, property %d: %w", i+1, n+1, err)
+			}
+		}
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+func addJCardProperty(card vcard.Card, p any) error {
+	prop, ok := p.([]any)
+	if !ok || len(prop) < 4 {
+		return fmt.Errorf("want [name, params, type, value...]")
+	}
+	name, ok1 := prop[0].(string)
+	params, ok2 := prop[1].(map[string]any)
+	typ, ok3 := prop[2].(string)
+	if !ok1 || !ok2 || !ok3 || name == "" {
+		return fmt.Errorf("want [name, params, type, value...]")
+	}
+	name = strings.ToUpper(name)
+	f := &vcard.Field{Params: vcard.Params{}}
+	for k, v := range params {
+		vals := []string{}
+		if list, ok := v.([]any); ok {
+			for _, x := range list {
+				vals = append(vals, jcardString(x))
+			}
+		} else {
+			vals = append(vals, jcardString(v))
+		}
+		if k == "group" {
+			f.Group = strings.Join(vals, "")
+			continue
+		}
+		f.Params[strings.ToUpper(k)] = vals
+	}
+	if !sameValueType(name, typ) {
+		f.Params.Set(vcard.ParamValue, typ)
+	}
+	vals := []string{}
+	for _, v := range prop[3:] {
+		if parts, ok := v.([]any); ok {
+			comps := []string{}
+			for _, c := range parts {
+				if multi, ok := c.([]any); ok {
+					items := []string{}
+					for _, x := range multi {
+						items = append(items, jcardString(x))
+					}
+					comps = append(comps, strings.Join(items, ","))
+				} else {
+					comps = append(comps, jcardString(c))
+				}
+			}
+			vals = append(vals, strings.Join(comps, ";"))
+			continue
+		}
+		s := jcardString(v)
+		if isDateType(typ) {
+			s = plainValue(s)
+		}
+		vals = append(vals, s)
+	}
+	sep := ","
+	if name == vcard.FieldOrganization {
+		sep = ";"
+	}
+	f.Value = strings.Join(vals, sep)
+	card.Add(name, f)
+	return nil
+}
+
+// jcardString renders a JSON scalar (string, number, boolean, null) as vCard text.
+func jcardString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
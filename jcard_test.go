@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// roundTripCards are the go-vcard cards the jCard and xCard round trips must preserve.
+const roundTripCards = "BEGIN:VCARD\r\n" +
+	"VERSION:4.0\r\n" +
+	"FN:José Ñúñez\r\n" +
+	"N:Ñúñez;José;Q.;Dr.;\r\n" +
+	"UID:urn:uuid:2b3c9a1e-7f0d-4c1e-9f35-0d2f8c1a6b11\r\n" +
+	"KIND:individual\r\n" +
+	"GENDER:M;he/him\r\n" +
+	"TEL;TYPE=cell,voice;PREF=1:+1 480 395 7551\r\n" +
+	"TEL;VALUE=uri;TYPE=work:tel:+91-98765-43210\r\n" +
+	"item1.EMAIL;TYPE=work:jose@example.com\r\n" +
+	"item1.X-ABLABEL:Office\r\n" +
+	"ADR;TYPE=home;LABEL=\"1 Main St\\nSpringfield\":;;1 Main St;Springfield;IL;62701;USA\r\n" +
+	"ORG:Acme\\, Inc.;R&D\r\n" +
+	"TITLE:Head of <Research> & \"Development\"\r\n" +
+	"CATEGORIES:friends,work\r\n" +
+	"NICKNAME:Pepe\r\n" +
+	"NOTE:first line\\nsecond line\\, with a comma\r\n" +
+	"BDAY:19850412\r\n" +
+	"ANNIVERSARY:--0517\r\n" +
+	"REV:20240101T120000Z\r\n" +
+	"URL:https://example.com/~jose\r\n" +
+	"PHOTO:data:image/png;base64,iVBORw0KGgo=\r\n" +
+	"X-DAV-BUCKET-REASON:left the company\r\n" +
+	"END:VCARD\r\n" +
+	"BEGIN:VCARD\r\n" +
+	"VERSION:4.0\r\n" +
+	"FN:Acme Support\r\n" +
+	"N:;;;;\r\n" +
+	"KIND:org\r\n" +
+	"ORG:Acme Support\r\n" +
+	"BDAY;VALUE=text:circa 1990\r\n" +
+	"EMAIL;PREF=2:help@acme.example\r\n" +
+	"END:VCARD\r\n"
+
+func decodeTestCards(t *testing.T, text string) []vcard.Card {
+	t.Helper()
+	cards, err := decodeVCFText([]byte(text))
+	if err != nil {
+		t.Fatalf("decode vCard: %v", err)
+	}
+	return cards
+}
+
+// sameCards compares cards property by property; go-vcard does not order parameters
+// when encoding, so encodeCard output cannot be compared directly.
+func sameCards(t *testing.T, want, got []vcard.Card) {
+	t.Helper()
+	if len(want) != len(got) {
+		t.Fatalf("got %d cards, want %d", len(got), len(want))
+	}
+	for i := range want {
+		for name, wf := range want[i] {
+			gf := got[i][name]
+			if len(gf) != len(wf) {
+				t.Errorf("card %d %s: got %d values, want %d", i+1, name, len(gf), len(wf))
+				continue
+			}
+			for n := range wf {
+				if gf[n].Value != wf[n].Value || gf[n].Group != wf[n].Group {
+					t.Errorf("card %d %s: got %q (group %q), want %q (group %q)", i+1, name, gf[n].Value, gf[n].Group, wf[n].Value, wf[n].Group)
+				}
+				if len(gf[n].Params)+len(wf[n].Params) > 0 && !reflect.DeepEqual(gf[n].Params, wf[n].Params) {
+					t.Errorf("card %d %s params: got %v, want %v", i+1, name, gf[n].Params, wf[n].Params)
+				}
+			}
+		}
+		for name := range got[i] {
+			if _, ok := want[i][name]; !ok {
+				t.Errorf("card %d: unexpected %s", i+1, name)
+			}
+		}
+	}
+}
+
+func TestJCardRoundTrip(t *testing.T) {
+	cards := decodeTestCards(t, roundTripCards)
+	data, err := encodeJCard(cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeJCard(data)
+	if err != nil {
+		t.Fatalf("decode jCard: %v\n%s", err, data)
+	}
+	sameCards(t, cards, got)
+}
+
+func TestJCardUpgradesVersion3(t *testing.T) {
+	cards := decodeTestCards(t, "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Jane Doe\r\nN:Doe;Jane;;;\r\n"+
+		"TEL;TYPE=cell,pref:+14803957551\r\nEMAIL;TYPE=internet,work:jane@example.com\r\n"+
+		"BDAY:1985-04-12\r\nX-ADDRESSBOOKSERVER-KIND:group\r\nEND:VCARD\r\n")
+	data, err := encodeJCard(cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeJCard(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameCards(t, []vcard.Card{asV4(cards[0])}, got)
+	if v := got[0].Value(vcard.FieldVersion); v != "4.0" {
+		t.Errorf("VERSION = %q, want 4.0", v)
+	}
+}
+
+func TestJCardRFCExample(t *testing.T) {
+	// RFC 7095 section 3.3.1.3 and 3.4 forms: structured values, multi-valued components,
+	// a lone jCard instead of an array, and extended dates.
+	data := `["vcard", [
+	  ["version", {}, "text", "4.0"],
+	  ["fn", {}, "text", "Simon Perreault"],
+	  ["n", {}, "text", ["Perreault", "Simon", "", "", ["ing. jr", "M.Sc."]]],
+	  ["bday", {}, "date-and-or-time", "--02-03"],
+	  ["tel", {"type": ["work", "voice"], "pref": "1"}, "uri", "tel:+1-418-656-9254;ext=102"],
+	  ["geo", {"type": "work"}, "uri", "geo:46.772673,-71.282945"],
+	  ["x-count", {}, "integer", 42]
+	]]`
+	cards, err := decodeJCard([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 {
+		t.Fatalf("got %d cards, want 1", len(cards))
+	}
+	c := cards[0]
+	for field, want := range map[string]string{
+		vcard.FieldName:      "Perreault;Simon;;;ing. jr,M.Sc.",
+		vcard.FieldBirthday:  "--0203",
+		vcard.FieldTelephone: "tel:+1-418-656-9254;ext=102",
+		"X-COUNT":            "42",
+	} {
+		if got := c.Value(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+	tel := c.Get(vcard.FieldTelephone)
+	if tel.Params.Get(vcard.ParamValue) != "uri" || strings.Join(tel.Params.Types(), ",") != "work,voice" {
+		t.Errorf("TEL params = %v", tel.Params)
+	}
+	if c.Get(vcard.FieldGeolocation).Params.Get(vcard.ParamValue) != "" {
+		t.Errorf("GEO: default uri type should not set VALUE")
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+
+	"github.com/user/dav-manager/internal/email"
+)
+
+// Lint severities, lowest first.
+var lintSeverities = []string{"info", "warning", "error"}
+
+func severityRank(s string) int {
+	for i, v := range lintSeverities {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// lintTarget is one card under inspection, on the server or inside a bucket VCF.
+type lintTarget struct {
+	Source string // server, bucket
+	Path   string // bucket file, or server href
+	Bucket string
+	Index  int // card position within a bucket file
+	Card   *vcard.Card
+	Ref    cardRef
+
+	changed bool
+	newPath string // set by the file-name fix
+}
+
+// lintRule is one standards check. fix, when set, mutates the target and reports
+// whether anything changed.
+type lintRule struct {
+	ID       string
+	Severity string
+	Summary  string
+	check    func(t *lintTarget) []string
+	fix      func(t *lintTarget) bool
+}
+
+// lintViolation is one reported problem.
+type lintViolation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Source   string `json:"source"`
+	Location string `json:"location"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+}
+
+var lintRules = []lintRule{
+	{
+		ID: "contact-method", Severity: "error", Summary: "card has at least one phone, email or address",
+		check: func(t *lintTarget) []string {
+			c := *t.Card
+			if len(c[vcard.FieldTelephone])+len(c[vcard.FieldEmail])+len(c[vcard.FieldAddress]) == 0 {
+				return []string{"no phone, email or address"}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "required-fields", Severity: "error", Summary: "card has the fields the rules file requires (per bucket for bucket cards)",
+		check: func(t *lintTarget) []string {
+			if missing := missingRequired(*t.Card, t.Bucket); len(missing) > 0 {
+				return []string{"missing required " + strings.Join(missing, ", ")}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "name-missing", Severity: "error", Summary: "card has a display name (FN)",
+		check: func(t *lintTarget) []string {
+			if strings.TrimSpace(t.Card.Value(vcard.FieldFormattedName)) == "" {
+				return []string{"FN is empty"}
+			}
+			return nil
+		},
+	},
+	{
+		ID: "phone-invalid", Severity: "error", Summary: "phone numbers are possible in their numbering plan",
+		check: func(t *lintTarget) []string {
+			out := []string{}
+			region := cardRegion(*t.Card)
+			for _, f := range (*t.Card)[vcard.FieldTelephone] {
+				if err := phoneProblem(f.Value, region); err != nil {
+					out = append(out, fmt.Sprintf("TEL %q: %v", f.Value, err))
+				}
+			}
+			return out
+		},
+	},
+	{
+		ID: "phone-format", Severity: "warning", Summary: "phones use spaced E.164, without duplicates",
+		check: func(t *lintTarget) []string {
+			out := []string{}
+			region := cardRegion(*t.Card)
+			seen := map[string]bool{}
+			for _, f := range (*t.Card)[vcard.FieldTelephone] {
+				if phoneProblem(f.Value, region) != nil {
+					continue
+				}
+				n := normalizePhoneIn(f.Value, region)
+				if n != f.Value {
+					out = append(out, fmt.Sprintf("TEL %q should be %q", f.Value, n))
+				}
+				if seen[n] {
+					out = append(out, fmt.Sprintf("TEL %q is duplicated", n))
+				}
+				seen[n] = true
+			}
+			return out
+		},
+		fix: func(t *lintTarget) bool { return fixWith(t.Card, normalizePhonesInCard) },
+	},
+	{
+		ID: "phone-label", Severity: "warning", Summary: "phones carry one of the standard TYPE labels",
+		check: func(t *lintTarget) []string {
+			out := []string{}
+			for _, f := range (*t.Card)[vcard.FieldTelephone] {
+				if phoneLabel(f) == "" {
+					out = append(out, fmt.Sprintf("TEL %q has no type", f.Value))
+					continue
+				}
+				for _, ty := range f.Params.Types() {
+					if !containsString(allowedPhoneLabels(), ty) && ty != "pref" && ty != "voice" {
+						out = append(out, fmt.Sprintf("TEL %q has non-standard type %q (use %s)", f.Value, ty, strings.Join(allowedPhoneLabels(), "|")))
+					}
+				}
+			}
+			return out
+		},
+		fix: func(t *lintTarget) bool { return fixWith(t.Card, normalizePhonesInCard) },
+	},
+	{
+		ID: "email-invalid", Severity: "error", Summary: "email addresses are syntactically valid",
+		check: func(t *lintTarget) []string {
+			out := []string{}
+			for _, f := range (*t.Card)[vcard.FieldEmail] {
+				if _, err := email.Normalize(f.Value); err != nil {
+					out = append(out, fmt.Sprintf("EMAIL %q: %v", f.Value, err))
+				}
+			}
+			return out
+		},
+	},
+	{
+		ID: "email-case", Severity: "warning", Summary: "emails are lowercase and not duplicated",
+		check: func(t *lintTarget) []string {
+			out := []string{}
+			seen := map[string]bool{}
+			for _, f := range (*t.Card)[vcard.FieldEmail] {
+				n, err := email.Normalize(f.Value)
+				if err != nil {
+					continue
+				}
+				if n != f.Value {
+					out = append(out, fmt.Sprintf("EMAIL %q should be %q", f.Value, n))
+				}
+				if seen[n] {
+					out = append(out, fmt.Sprintf("EMAIL %q is duplicated", n))
+				} else {
+					seen[n] = true
+				}
+			}
+			return out
+		},
+		fix: func(t *lintTarget) bool { return fixWith(t.Card, normalizeEmailsInCard) },
+	},
+	{
+		ID: "email-label", Severity: "info", Summary: "emails carry one of the standard TYPE labels",
+		check: func(t *lintTarget) []string {
+			out := []string{}
+			for _, f := range (*t.Card)[vcard.FieldEmail] {
+				for _, ty := range f.Params.Types() {
+					if !containsString(allowedEmailLabels(), ty) && ty != "pref" && ty != "internet" {
+						out = append(out, fmt.Sprintf("EMAIL %q has non-standard type %q (use %s)", f.Value, ty, strings.Join(allowedEmailLabels(), "|")))
+					}
+				}
+			}
+			return out
+		},
+	},
+	{
+		ID: "name-structured", Severity: "warning", Summary: "structured N is split into components and matches FN",
+		check: func(t *lintTarget) []string {
+			c := *t.Card
+			if strings.TrimSpace(c.Value(vcard.FieldFormattedName)) == "" {
+				return nil
+			}
+			tmp := vcard.Card{}
+			setStructuredName(&tmp, c.Value(vcard.FieldFormattedName), "")
+			want := tmp.Value(vcard.FieldName)
+			switch {
+			case cardName(c).Components() == 0:
+				return []string{fmt.Sprintf("N is missing (want %q)", want)}
+			case legacyName(c) && structuredNames():
+				return []string{fmt.Sprintf("N %q is the whole FN in one component (want %q)", c.Value(vcard.FieldName), want)}
+			case !nameInSync(c):
+				return []string{fmt.Sprintf("N %q does not match FN (want %q)", c.Value(vcard.FieldName), want)}
+			}
+			return nil
+		},
+		fix: func(t *lintTarget) bool { return syncStructuredName(t.Card, true) },
+	},
+	{
+		ID: "name-honorific", Severity: "warning", Summary: "display names carry no honorifics (they belong in NOTE)",
+		check: func(t *lintTarget) []string {
+			if _, h := displayName(t.Card.Value(vcard.FieldFormattedName)); len(h) > 0 {
+				return []string{fmt.Sprintf("FN starts with honorific %q", strings.Join(h, " "))}
+			}
+			return nil
+		},
+		fix: func(t *lintTarget) bool { return len(normalizeDisplayName(t.Card)) > 0 },
+	},
+	{
+		ID: "name-display", Severity: "info", Summary: "display names are Title Case without emoji or invisible characters",
+		check: func(t *lintTarget) []string {
+			fn := t.Card.Value(vcard.FieldFormattedName)
+			clean, h := displayName(fn)
+			if clean == "" || len(h) > 0 || clean == fn {
+				return nil
+			}
+			return []string{fmt.Sprintf("FN %q should be %q", fn, clean)}
+		},
+		fix: func(t *lintTarget) bool { return len(normalizeDisplayName(t.Card)) > 0 },
+	},
+	{
+		ID: "file-name", Severity: "warning", Summary: "bucket files have kebab-case names",
+		check: func(t *lintTarget) []string {
+			if t.Source != "bucket" || t.Index > 0 {
+				return nil
+			}
+			base := filepath.Base(t.Path)
+			if want := kebabFileName(t.Path); base != want {
+				return []string{fmt.Sprintf("file %q should be %q", base, want)}
+			}
+			return nil
+		},
+		fix: func(t *lintTarget) bool {
+			if t.Source != "bucket" || t.Index > 0 {
+				return false
+			}
+			want := filepath.Join(filepath.Dir(t.Path), kebabFileName(t.Path))
+			if want == t.Path {
+				return false
+			}
+			if storeExists(want) {
+				return false
+			}
+			t.newPath = want
+			return true
+		},
+	},
+}
+
+// fixWith runs a normalizer on the card and reports whether its serialized form changed.
+func fixWith(card *vcard.Card, fn func(*vcard.Card)) bool {
+	before := encodeCard(*card)
+	fn(card)
+	return encodeCard(*card) != before
+}
+
+// encodeCard writes the card as vCard text, with URI values left unescaped.
+func encodeCard(card vcard.Card) string {
+	var b strings.Builder
+	_ = vcard.NewEncoder(&b).Encode(card)
+	return unescapeURIValues(b.String())
+}
+
+func kebabFileName(path string) string {
+	stem := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if s := safeFileName(stem); s != "" {
+		stem = s
+	}
+	return stem + ".vcf"
+}
+
+// runLint checks server cards and/or bucket VCFs against lintRules, optionally fixing
+// what can be fixed, prints the remaining violations and exits 1 when any reaches failOn.
+func runLint(scope, format, failOn string, fix bool) {
+	if severityRank(failOn) < 0 {
+		log.Fatalf("lint: --fail-on must be one of %s", strings.Join(lintSeverities, "|"))
+	}
+	if scope != "all" && scope != "server" && scope != "buckets" {
+		log.Fatalf("lint: --scope must be all|server|buckets")
+	}
+	if err := checkLintConfig(); err != nil {
+		log.Fatalf("rules: %v", err)
+	}
+	targets := []*lintTarget{}
+	var client *radClient
+	if scope == "all" || scope == "server" {
+		client = newClient()
+		for _, cd := range mustFetch(client) {
+			card := cd.Card
+			targets = append(targets, &lintTarget{Source: "server", Path: cd.Ref.Href, Card: &card, Ref: cd.Ref})
+		}
+	}
+	files := map[string][]*lintTarget{}
+	if scope == "all" || scope == "buckets" {
+		root := bucketRoot()
+		for _, bucket := range bucketNames(root) {
+			for _, path := range bucketVCFs(filepath.Join(root, bucket)) {
+				cards, err := readVCF(path)
+				if err != nil {
+					// the cards read so far are still checked, but --fix must not rewrite
+					// (and so truncate) a file that did not decode completely
+					log.Printf("[warn] lint %s: %v (left untouched by --fix)", path, err)
+				}
+				for i := range cards {
+					t := &lintTarget{Source: "bucket", Path: path, Bucket: bucket, Index: i, Card: &cards[i]}
+					targets = append(targets, t)
+					if err == nil {
+						files[path] = append(files[path], t)
+					}
+				}
+			}
+		}
+	}
+
+	if fix {
+		fixLintTargets(client, targets, files)
+	}
+
+	violations := []lintViolation{}
+	for _, t := range targets {
+		for _, r := range lintRules {
+			if !lintEnabled(r.ID) {
+				continue
+			}
+			for _, msg := range r.check(t) {
+				violations = append(violations, lintViolation{
+					Rule: r.ID, Severity: lintSeverity(r), Source: t.Source, Location: t.Path,
+					Name: t.Card.Value(vcard.FieldFormattedName), Message: msg,
+				})
+			}
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		a, b := violations[i], violations[j]
+		if severityRank(a.Severity) != severityRank(b.Severity) {
+			return severityRank(a.Severity) > severityRank(b.Severity)
+		}
+		return a.Location < b.Location
+	})
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(violations)
+	case "sarif":
+		writeSARIF(os.Stdout, violations)
+	case "text":
+		writeLintText(os.Stdout, violations)
+	default:
+		log.Fatalf("lint: --format must be text|json|sarif")
+	}
+
+	for _, v := range violations {
+		if severityRank(v.Severity) >= severityRank(failOn) {
+			closeBuckets()
+			os.Exit(1)
+		}
+	}
+}
+
+// fixLintTargets applies every available fix and writes changed cards back: server cards
+// via PUT, bucket files rewritten (and renamed by the file-name fix).
+func fixLintTargets(client *radClient, targets []*lintTarget, files map[string][]*lintTarget) {
+	ctx := context.Background()
+	fixed := 0
+	for _, t := range targets {
+		if t.Source == "bucket" && files[t.Path] == nil {
+			continue // partially decoded file
+		}
+		for _, r := range lintRules {
+			if r.fix != nil && lintEnabled(r.ID) && len(r.check(t)) > 0 && r.fix(t) {
+				t.changed = true
+			}
+		}
+		if !t.changed || t.Source != "server" {
+			continue
+		}
+		fixed++
+		setRevNow(t.Card)
+		if err := client.put(ctx, t.Ref, *t.Card); err != nil {
+			log.Printf("lint fix put %s: %v", t.Ref.Href, err)
+		}
+	}
+	for path, list := range files {
+		dirty := false
+		cards := []vcard.Card{}
+		for _, t := range list {
+			dirty = dirty || t.changed
+			ensureUID(t.Card)
+			if t.changed {
+				setRevNow(t.Card)
+			}
+			cards = append(cards, *t.Card)
+		}
+		newPath := list[0].newPath
+		if !dirty && newPath == "" {
+			continue
+		}
+		fixed++
+		if dirty {
+			if err := writeVCF(path, cards); err != nil {
+				log.Printf("lint fix write %s: %v", path, err)
+				continue
+			}
+		}
+		if newPath != "" {
+			if err := storeRename(path, newPath); err != nil {
+				log.Printf("lint fix rename %s: %v", path, err)
+				continue
+			}
+			for _, t := range list {
+				t.Path = newPath
+			}
+		}
+	}
+	log.Printf("lint --fix rewrote %d card(s)/file(s)", fixed)
+}
+
+func writeLintText(w io.Writer, violations []lintViolation) {
+	counts := map[string]int{}
+	for _, v := range violations {
+		counts[v.Severity]++
+		fmt.Fprintf(w, "%-7s  %-15s  %s  %s: %s\n", v.Severity, v.Rule, v.Location, v.Name, v.Message)
+	}
+	fmt.Fprintf(w, "%d problem(s): %d error(s), %d warning(s), %d info\n",
+		len(violations), counts["error"], counts["warning"], counts["info"])
+}
+
+// writeSARIF emits a minimal SARIF 2.1.0 log so CI systems can annotate findings.
+func writeSARIF(w io.Writer, violations []lintViolation) {
+	level := map[string]string{"error": "error", "warning": "warning", "info": "note"}
+	type msg struct {
+		Text string `json:"text"`
+	}
+	rules := []map[string]any{}
+	for _, r := range lintRules {
+		rules = append(rules, map[string]any{
+			"id":                   r.ID,
+			"shortDescription":     msg{r.Summary},
+			"defaultConfiguration": map[string]string{"level": level[lintSeverity(r)]},
+		})
+	}
+	results := []map[string]any{}
+	for _, v := range violations {
+		results = append(results, map[string]any{
+			"ruleId":  v.Rule,
+			"level":   level[v.Severity],
+			"message": msg{v.Name + ": " + v.Message},
+			"locations": []map[string]any{{
+				"physicalLocation": map[string]any{"artifactLocation": map[string]string{"uri": v.Location}},
+			}},
+		})
+	}
+	doc := map[string]any{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]any{{
+			"tool":    map[string]any{"driver": map[string]any{"name": "dav-manager", "rules": rules}},
+			"results": results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(doc)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/md5"
 	"crypto/rand"
@@ -8,6 +9,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -16,10 +18,14 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	vcard "github.com/emersion/go-vcard"
+
+	"github.com/user/dav-manager/internal/email"
+	"github.com/user/dav-manager/internal/phone"
 )
 
 // Environment variables:
@@ -28,6 +34,15 @@ import (
 // RADICALE_USER / RADICALE_PASS
 // UN_CONTACTS (default: /home/pi/data/smbfs/dada/un-contacts)
 // PHOTO_MAP (default: photo-map.json), ENABLE_GRAVATAR (default: 0)
+// NAME_OVERRIDES (default: name-overrides.json) explicit N components for names the parser splits wrongly
+// EMAIL_ALIAS_DEDUPE (default: 0) fold Gmail dots/+tags etc. when detecting duplicate emails
+// DEFAULT_REGION (default: IN) region for national numbers and "home numbers last" ordering
+// RULES_FILE (default: dav-rules.yaml) policy overrides for lint and normalization (see rules.go)
+// BUCKET_STORE (default: UN_CONTACTS) bucket tree location: directory, WebDAV URL or .zip archive (see store.go)
+// BUCKET_STORE_USER / BUCKET_STORE_PASS (default: RADICALE_USER / RADICALE_PASS) WebDAV store credentials
+// BUCKET_COLLECTION_PREFIX (default: <parent of RADICALE_COLLECTION>/un-) collections for `buckets mirror`
+// BUCKET_PASSPHRASE / BUCKET_PASSPHRASE_FILE encrypt bucket files (see crypt.go)
+// BUCKET_INDEX (default: <user cache dir>/dav-manager/buckets-<hash>.json) local bucket index (see index.go)
 
 type cardRef struct {
 	Href string
@@ -44,6 +59,8 @@ type desiredEntry struct {
 	Emails []string
 	Phones []string
 	Note   string
+	Region string // optional per-contact DEFAULT_REGION override
+	N      string // optional explicit "family;given;additional;prefix;suffix"
 }
 
 func main() {
@@ -80,16 +97,27 @@ func contactsMain(args []string) {
 		contactsUsage()
 		return
 	}
+	loadDotEnv()
+	if r := os.Getenv("DEFAULT_REGION"); r != "" && !validRegion(r) {
+		log.Fatalf("DEFAULT_REGION %q is not a known region code (e.g. IN, US, GB)", r)
+	}
+	rules() // fail early on a malformed rules file
+	defer closeBuckets()
 	switch args[0] {
 	case "fetch":
 		fetchCmd := flag.NewFlagSet("fetch", flag.ExitOnError)
 		source := fetchCmd.String("source", "", "optional markdown file to rebuild after fetch")
 		touchAll := fetchCmd.Bool("touch-all", false, "update REV on all cards (apply immediately)")
 		unBuckets := fetchCmd.Bool("un-contacts", false, "list UN_CONTACTS buckets instead of server contacts")
+		category := fetchCmd.String("category", "", "only list contacts tagged with this CATEGORIES value")
+		format := fetchCmd.String("format", "table", "output format: table, jcard or xcard")
 		fetchCmd.Parse(args[1:])
+		if *format != "table" && *format != "jcard" && *format != "xcard" {
+			log.Fatalf("fetch: --format must be table, jcard or xcard")
+		}
 		client := newClient()
 		if *unBuckets {
-			printBuckets(getenv("UN_CONTACTS", "/home/pi/data/smbfs/dada/un-contacts"))
+			printBuckets(bucketRoot())
 			return
 		}
 		infos := mustFetch(client)
@@ -103,7 +131,20 @@ func contactsMain(args []string) {
 			}
 			infos = mustFetch(client) // refetch after touch
 		}
-		printTable(infos)
+		if *category != "" {
+			infos = filterByCategory(infos, *category)
+		}
+		if *format == "table" {
+			printTable(infos)
+		} else {
+			cards := []vcard.Card{}
+			for _, cd := range infos {
+				cards = append(cards, cd.Card)
+			}
+			if err := writeCardsAs(os.Stdout, *format, cards); err != nil {
+				log.Fatalf("fetch: %v", err)
+			}
+		}
 		if *source != "" {
 			writeTable(*source, infos)
 			log.Printf("Wrote %s", *source)
@@ -112,32 +153,51 @@ func contactsMain(args []string) {
 		addCmd := flag.NewFlagSet("add", flag.ExitOnError)
 		name := addCmd.String("name", "", "name (required)")
 		emails := addCmd.String("emails", "", "comma-separated emails")
-		phones := addCmd.String("phones", "", "comma-separated phones")
+		phones := addCmd.String("phones", "", "comma-separated phones (number[:cell|work|home|fax|other][:pref])")
 		note := addCmd.String("note", "", "note")
+		region := addCmd.String("region", "", "region for national phone numbers (overrides DEFAULT_REGION for this contact)")
+		n := addCmd.String("n", "", "explicit structured name \"family;given;additional;prefix;suffix\" (default: parsed from --name)")
 		addCmd.Parse(args[1:])
 		if *name == "" {
 			log.Fatalf("name is required")
 		}
+		if *region != "" && !validRegion(*region) {
+			log.Fatalf("add: region %q is not a known region code", *region)
+		}
+		checkNameOverrideOrDie("add", *n)
+		checkPhonesOrDie("add", splitCSV(*phones), *region)
+		checkEmailsOrDie("add", splitCSV(*emails))
 		client := newClient()
 		addEntry(client, desiredEntry{
 			Name:   *name,
 			Emails: splitCSV(*emails),
 			Phones: splitCSV(*phones),
 			Note:   *note,
+			Region: *region,
+			N:      *n,
 		})
 	case "update":
 		upCmd := flag.NewFlagSet("update", flag.ExitOnError)
 		name := upCmd.String("name", "", "existing name (required)")
 		newName := upCmd.String("new-name", "", "new name")
 		emails := upCmd.String("emails", "", "replace emails (comma-separated)")
-		phones := upCmd.String("phones", "", "replace phones (comma-separated)")
+		phones := upCmd.String("phones", "", "replace phones (comma-separated, number[:type][:pref])")
 		note := upCmd.String("note", "", "set note (empty to clear)")
+		region := upCmd.String("region", "", "set the contact's phone region override (e.g. US, GB)")
+		demote := upCmd.String("demote-email", "", "move these stale emails (comma-separated) from EMAIL into NOTE")
+		n := upCmd.String("n", "", "explicit structured name \"family;given;additional;prefix;suffix\"")
 		upCmd.Parse(args[1:])
 		if *name == "" {
 			log.Fatalf("name is required")
 		}
+		if *region != "" && !validRegion(*region) {
+			log.Fatalf("update: region %q is not a known region code", *region)
+		}
+		checkNameOverrideOrDie("update", *n)
+		checkPhonesOrDie("update", splitCSV(*phones), *region)
+		checkEmailsOrDie("update", splitCSV(*emails))
 		client := newClient()
-		updateEntry(client, *name, *newName, splitCSV(*emails), splitCSV(*phones), note)
+		updateEntry(client, *name, *newName, *region, *n, splitCSV(*emails), splitCSV(*phones), splitCSV(*demote), note)
 	case "delete", "remove", "rm":
 		rmCmd := flag.NewFlagSet("delete", flag.ExitOnError)
 		name := rmCmd.String("name", "", "name to delete (required)")
@@ -153,21 +213,31 @@ func contactsMain(args []string) {
 		name := mvCmd.String("name", "", "name to move (required)")
 		bucket := mvCmd.String("bucket", "", "target bucket under UN_CONTACTS (required)")
 		newName := mvCmd.String("new-name", "", "optional new name before move")
+		reason := mvCmd.String("reason", "", "why the contact is bucketed (stored as X-DAV-BUCKET-REASON)")
 		mvCmd.Parse(args[1:])
 		if *name == "" || *bucket == "" {
 			log.Fatalf("move: --name and --bucket are required")
 		}
-		moveEntry(newClient(), *name, *bucket, *newName)
+		moveEntry(newClient(), *name, *bucket, *newName, *reason)
 	case "restore":
 		rsCmd := flag.NewFlagSet("restore", flag.ExitOnError)
-		name := rsCmd.String("name", "", "name to restore (required)")
-		bucket := rsCmd.String("bucket", "", "bucket under UN_CONTACTS to restore from (required)")
+		var q restoreQuery
+		rsCmd.StringVar(&q.Name, "name", "", "name (FN or original FN) to restore")
+		rsCmd.StringVar(&q.Phone, "phone", "", "phone number to restore (any format)")
+		rsCmd.StringVar(&q.Email, "email", "", "email address to restore")
+		rsCmd.StringVar(&q.UID, "uid", "", "UID of the card to restore")
+		rsCmd.StringVar(&q.Path, "path", "", "bucket VCF to restore (relative to UN_CONTACTS or full path)")
+		rsCmd.StringVar(&q.Bucket, "bucket", "", "only look in this bucket (default: all buckets)")
+		all := rsCmd.Bool("all", false, "restore every match (e.g. --all --bucket neutral restores the whole bucket)")
 		keepSource := rsCmd.Bool("keep-source", false, "keep the source VCF in UN_CONTACTS (default deletes it)")
 		rsCmd.Parse(args[1:])
-		if *name == "" || *bucket == "" {
-			log.Fatalf("restore: --name and --bucket are required")
+		if q.empty() {
+			log.Fatalf("restore: give --name, --phone, --email, --uid, --path or --all --bucket NAME")
 		}
-		restoreEntry(newClient(), *name, *bucket, *keepSource)
+		if q == (restoreQuery{Bucket: q.Bucket}) && !*all {
+			log.Fatalf("restore: --bucket alone needs --all to restore the whole bucket")
+		}
+		restoreEntries(newClient(), q, *all, *keepSource)
 	case "sync":
 		syncCmd := flag.NewFlagSet("sync", flag.ExitOnError)
 		source := syncCmd.String("source", "docs/examples/example-table.md", "markdown table to sync from")
@@ -175,6 +245,51 @@ func contactsMain(args []string) {
 		touch := syncCmd.Bool("touch", false, "force-update REV on all cards")
 		syncCmd.Parse(args[1:])
 		runSync(*source, *apply, *touch)
+	case "import":
+		impCmd := flag.NewFlagSet("import", flag.ExitOnError)
+		format := impCmd.String("format", "", "input format: vcf, jcard, xcard or google-csv (default: from the file extension)")
+		apply := impCmd.Bool("apply", false, "apply changes (default dry-run)")
+		impCmd.Parse(args[1:])
+		file := impCmd.Arg(0)
+		impCmd.Parse(impCmd.Args()[min(1, impCmd.NArg()):]) // flags may follow FILE
+		if file == "" {
+			log.Fatalf("import: FILE is required")
+		}
+		if *format == "" {
+			*format = map[string]string{".vcf": "vcf", ".vcard": "vcf", ".json": "jcard", ".jcard": "jcard", ".xml": "xcard", ".xcard": "xcard", ".csv": "google-csv"}[strings.ToLower(filepath.Ext(file))]
+		}
+		switch *format {
+		case "vcf":
+			importCardFile(file, decodeVCFText, *apply)
+		case "jcard":
+			importCardFile(file, decodeJCard, *apply)
+		case "xcard":
+			importCardFile(file, decodeXCard, *apply)
+		case "google-csv":
+			importGoogleCSV(file, *apply)
+		default:
+			log.Fatalf("import: --format must be vcf, jcard, xcard or google-csv")
+		}
+	case "export":
+		expCmd := flag.NewFlagSet("export", flag.ExitOnError)
+		format := expCmd.String("format", "", "output format: google-csv, jcard or xcard (required)")
+		out := expCmd.String("out", "", "write to FILE instead of stdout")
+		expCmd.Parse(args[1:])
+		switch *format {
+		case "google-csv", "jcard", "xcard":
+			exportContacts(*format, *out)
+		default:
+			log.Fatalf("export: --format must be google-csv, jcard or xcard")
+		}
+	case "convert":
+		convCmd := flag.NewFlagSet("convert", flag.ExitOnError)
+		to := convCmd.String("to", "", "target vCard version: 2.1, 3.0 or 4.0 (required)")
+		scope := convCmd.String("scope", "server", "what to convert: server|buckets|all")
+		file := convCmd.String("file", "", "convert this .vcf instead (any version in, written to --out or stdout)")
+		out := convCmd.String("out", "", "output file for --file (default stdout)")
+		apply := convCmd.Bool("apply", false, "apply changes (default dry-run; --file always writes)")
+		convCmd.Parse(args[1:])
+		runConvert(*to, *scope, *file, *out, *apply)
 	case "photos":
 		photoCmd := flag.NewFlagSet("photos", flag.ExitOnError)
 		apply := photoCmd.Bool("apply", false, "apply changes (default dry-run)")
@@ -202,8 +317,45 @@ func contactsMain(args []string) {
 	case "fix-names":
 		fixCmd := flag.NewFlagSet("fix-names", flag.ExitOnError)
 		apply := fixCmd.Bool("apply", false, "apply changes (default dry-run)")
+		structured := fixCmd.Bool("structured", false, "also split N values that hold the whole FN in one component")
 		fixCmd.Parse(args[1:])
-		fixNames(*apply)
+		fixNames(*apply, *structured)
+	case "lint":
+		lintCmd := flag.NewFlagSet("lint", flag.ExitOnError)
+		scope := lintCmd.String("scope", "all", "what to check: all|server|buckets")
+		format := lintCmd.String("format", "text", "output format: text|json|sarif")
+		failOn := lintCmd.String("fail-on", "warning", "exit 1 when a violation of this severity or worse remains: error|warning|info")
+		fix := lintCmd.Bool("fix", false, "apply automatic fixes (server PUTs, bucket files rewritten/renamed) before reporting")
+		lintCmd.Parse(args[1:])
+		runLint(*scope, *format, *failOn, *fix)
+	case "tag":
+		if len(args) < 2 {
+			log.Fatalf("tag: use tag add|remove|list [--name NAME] [--tags a,b]")
+		}
+		tagCmd := flag.NewFlagSet("tag "+args[1], flag.ExitOnError)
+		name := tagCmd.String("name", "", "contact name (required for add/remove; optional for list)")
+		tags := tagCmd.String("tags", "", "comma-separated categories")
+		tagCmd.Parse(args[2:])
+		runTag(args[1], *name, splitCSV(*tags))
+	case "buckets":
+		runBuckets(args[1:])
+	case "triage":
+		triCmd := flag.NewFlagSet("triage", flag.ExitOnError)
+		apply := triCmd.Bool("apply", false, "move routed cards (default dry-run report)")
+		triCmd.Parse(args[1:])
+		runTriage(*apply)
+	case "normalize-names":
+		nnCmd := flag.NewFlagSet("normalize-names", flag.ExitOnError)
+		apply := nnCmd.Bool("apply", false, "apply changes (default dry-run diff)")
+		nnCmd.Parse(args[1:])
+		normalizeNames(*apply)
+	case "review":
+		revCmd := flag.NewFlagSet("review", flag.ExitOnError)
+		dups := revCmd.Bool("duplicates", false, "page through duplicate clusters (same name/phone/email) instead of single cards")
+		apply := revCmd.Bool("apply", false, "apply queued actions after confirmation (default dry-run)")
+		backupDir := revCmd.String("backup-dir", ".", "directory for backups of cards deleted during review")
+		revCmd.Parse(args[1:])
+		runReview(*dups, *apply, *backupDir)
 	case "help", "-h", "--help":
 		contactsUsage()
 	default:
@@ -214,17 +366,26 @@ func contactsMain(args []string) {
 func contactsUsage() {
 	fmt.Println("Usage: dav contacts <command> [options]")
 	fmt.Println("Commands:")
-	fmt.Println("  fetch          list contacts (fancy table) or buckets with --un-contacts; use --touch-all to bump REV")
-	fmt.Println("  add            --name NAME [--emails e1,e2] [--phones p1,p2] [--note text]")
-	fmt.Println("  update         --name NAME [--new-name NN] [--emails ...] [--phones ...] [--note text]")
+	fmt.Println("  fetch          list contacts (fancy table, or --format jcard|xcard) or buckets with --un-contacts; --category TAG filters; --touch-all bumps REV")
+	fmt.Println("  add            --name NAME [--emails e1,e2] [--phones p1[:type[:pref]],p2] [--note text] [--region US] [--n \"Family;Given\"]")
+	fmt.Println("  update         --name NAME [--new-name NN] [--emails ...] [--phones ...] [--note text] [--region US] [--demote-email old@x] [--n \"Family;Given\"]")
 	fmt.Println("  delete         --name NAME [--vcf /path/to/backup.vcf]")
-	fmt.Println("  move           --name NAME --bucket psychology|corporate|... [--new-name NN]")
-	fmt.Println("  restore        --name NAME --bucket psychology|corporate|... [--keep-source]")
+	fmt.Println("  move           --name NAME --bucket psychology|corporate|... [--new-name NN] [--reason TEXT]")
+	fmt.Println("  restore        --name N|--phone N|--email E|--uid U|--path P [--bucket B] [--all] [--keep-source]")
 	fmt.Println("  sync           --source FILE [--apply] [--touch]  # reconcile to markdown table; extras go to UN_CONTACTS/neutral")
+	fmt.Println("  import         FILE.vcf|.json|.xml|.csv [--format vcf|jcard|xcard|google-csv] [--apply]  # plan creates/merges against server cards")
+	fmt.Println("  export         --format google-csv|jcard|xcard [--out FILE]  # write server contacts for Google Contacts or other tooling")
+	fmt.Println("  convert        --to 2.1|3.0|4.0 [--scope server|buckets|all] [--apply] | --file IN.vcf [--out OUT.vcf]  # vCard version conversion")
 	fmt.Println("  photos         [--apply] [--force] [--map photo-map.json] [--gravatar bool]  # apply photo map/gravatar")
 	fmt.Println("  clean-buckets  [--apply]  # normalize bucket phone ordering/format; warn on missing phones")
 	fmt.Println("  refresh-uids   [--apply]  # recreate all server contacts with new UIDs/hrefs to force client refresh")
-	fmt.Println("  fix-names      [--apply]  # set structured N to match FN for all server contacts")
+	fmt.Println("  fix-names      [--apply] [--structured]  # derive structured N (family;given;...) from FN for all server contacts")
+	fmt.Println("  normalize-names [--apply]  # Title Case display names, honorifics to NOTE, strip emoji/invisible chars")
+	fmt.Println("  lint           [--scope all|server|buckets] [--format text|json|sarif] [--fail-on warning] [--fix]  # check cards against the standards; exit 1 on violations")
+	fmt.Println("  tag            add|remove|list [--name NAME] [--tags a,b]  # manage CATEGORIES on server cards")
+	fmt.Println("  buckets        mirror|search|stats|reindex|due|expire|dedupe|encrypt|decrypt ...  # bucket maintenance (see: dav contacts buckets)")
+	fmt.Println("  triage         [--apply]  # route cards without phone/email/address to neutral, email-only to email_only, companies to corporate")
+	fmt.Println("  review         [--duplicates] [--apply] [--backup-dir DIR]  # interactive keep/edit/merge/move/delete queue")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  dav contacts fetch --touch-all")
@@ -232,11 +393,50 @@ func contactsUsage() {
 	fmt.Println("  dav contacts add --name \"Jane Doe\" --phones \"+1 4803957551,+91 9876543210\"")
 	fmt.Println("  dav contacts move --name \"Vendor X\" --bucket corporate --new-name \"Vendor X (2019)\"")
 	fmt.Println("  dav contacts restore --name \"Vendor X (2019)\" --bucket corporate")
+	fmt.Println("  dav contacts restore --phone \"98765 43210\"")
 	fmt.Println("  dav contacts delete --name \"Noise Lead\" --vcf \"$UN_CONTACTS/psychology/noise-lead.vcf\"")
 	fmt.Println("  dav contacts photos --apply --gravatar")
+	fmt.Println("  dav contacts review --duplicates --apply")
 	fmt.Println("  dav contacts sync --source docs/examples/example-table.md --apply --touch")
 }
 
+// checkPhonesOrDie rejects impossible numbers given on the command line.
+func checkPhonesOrDie(cmd string, nums []string, region string) {
+	if region == "" {
+		region = defaultRegion()
+	}
+	bad := []string{}
+	for _, n := range nums {
+		f, err := parsePhoneSpec(n)
+		if err != nil {
+			log.Fatalf("%s: %v", cmd, err)
+		}
+		if err := phoneProblem(f.Value, strings.ToUpper(region)); err != nil {
+			bad = append(bad, fmt.Sprintf("%q (%v)", n, err))
+		}
+	}
+	if len(bad) > 0 {
+		log.Fatalf("%s: invalid phone number(s): %s", cmd, strings.Join(bad, ", "))
+	}
+}
+
+// checkEmailsOrDie rejects malformed addresses given on the command line.
+func checkEmailsOrDie(cmd string, specs []string) {
+	bad := []string{}
+	for _, spec := range specs {
+		f, err := parseEmailSpec(spec)
+		if err != nil {
+			log.Fatalf("%s: %v", cmd, err)
+		}
+		if _, err := email.Normalize(f.Value); err != nil {
+			bad = append(bad, fmt.Sprintf("%q (%v)", spec, err))
+		}
+	}
+	if len(bad) > 0 {
+		log.Fatalf("%s: invalid email(s): %s", cmd, strings.Join(bad, ", "))
+	}
+}
+
 // touchAllCards bumps REV on all provided cards.
 func touchAllCards(client *radClient, cards []cardData) {
 	ctx := context.Background()
@@ -329,6 +529,9 @@ func (c *radClient) get(ctx context.Context, ref cardRef) (cardData, error) {
 		return cardData{}, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return cardData{}, fmt.Errorf("get %s: %w", ref.Href, errCardNotFound)
+	}
 	if resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(resp.Body)
 		return cardData{}, fmt.Errorf("get status %d: %s", resp.StatusCode, string(b))
@@ -341,22 +544,48 @@ func (c *radClient) get(ctx context.Context, ref cardRef) (cardData, error) {
 	return cardData{Ref: ref, Card: card}, nil
 }
 
+// errCardNotFound and errCardExists report a 404 from get and a 412 from create.
+var (
+	errCardNotFound = errors.New("no card at this href")
+	errCardExists   = errors.New("a card already exists at this href")
+)
+
+// put uploads card, first converting it in place to the collection's vCard version when
+// the rules file sets one (see collectionVersion).
 func (c *radClient) put(ctx context.Context, ref cardRef, card vcard.Card) error {
+	if ref.ETag != "" {
+		return c.upload(ctx, ref, card, "If-Match", ref.ETag)
+	}
+	return c.upload(ctx, ref, card, "", "")
+}
+
+// create is put for a new href: it fails with errCardExists instead of overwriting.
+func (c *radClient) create(ctx context.Context, ref cardRef, card vcard.Card) error {
+	return c.upload(ctx, ref, card, "If-None-Match", "*")
+}
+
+func (c *radClient) upload(ctx context.Context, ref cardRef, card vcard.Card, condition, value string) error {
 	url := ref.Href
 	if !strings.HasPrefix(url, "http") {
 		url = c.base + strings.TrimPrefix(ref.Href, "/")
 	}
+	if v := collectionVersion(c.collection); v != "" {
+		convertCard(&card, v)
+	}
 	body := serializeCard(card)
 	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, url, strings.NewReader(body))
 	req.SetBasicAuth(c.user, c.pass)
-	if ref.ETag != "" {
-		req.Header.Set("If-Match", ref.ETag)
+	if condition != "" {
+		req.Header.Set(condition, value)
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed && condition == "If-None-Match" {
+		return fmt.Errorf("put %s: %w", ref.Href, errCardExists)
+	}
 	if resp.StatusCode >= 300 {
 		b, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("put status %d: %s", resp.StatusCode, string(b))
@@ -414,7 +643,7 @@ func printTable(cards []cardData) {
 	for _, c := range cards {
 		fn := strings.TrimSpace(c.Card.Value(vcard.FieldFormattedName))
 		em := strings.Join(getValues(c.Card, vcard.FieldEmail), ", ")
-		ph := strings.Join(getValues(c.Card, vcard.FieldTelephone), ", ")
+		ph := strings.Join(phoneSpecs(c.Card), ", ")
 		rows = append(rows, row{fn, em, ph})
 		if len(fn) > nameW {
 			nameW = len(fn)
@@ -435,14 +664,16 @@ func printTable(cards []cardData) {
 
 func printBuckets(root string) {
 	type row struct {
-		Bucket string
-		Name   string
-		Emails string
-		Phones string
+		Bucket   string
+		Name     string
+		Emails   string
+		Phones   string
+		Bucketed string
+		Reason   string
 	}
 	rows := []row{}
 	entries := listBucketEntries(root)
-	bW, nW, eW, pW := len("Bucket"), len("Name"), len("Emails"), len("Phones")
+	bW, nW, eW, pW, dW := len("Bucket"), len("Name"), len("Emails"), len("Phones"), len("Bucketed")
 	keys := []string{}
 	for k := range entries {
 		keys = append(keys, k)
@@ -454,7 +685,11 @@ func printBuckets(root string) {
 			return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
 		})
 		for _, ent := range list {
-			rows = append(rows, row{bucket, ent.Name, ent.Emails, ent.Phones})
+			reason := ent.Reason
+			if ent.Original != "" {
+				reason = strings.TrimSpace(reason + " (was " + ent.Original + ")")
+			}
+			rows = append(rows, row{bucket, ent.Name, ent.Emails, ent.Phones, ent.Bucketed, reason})
 			if len(bucket) > bW {
 				bW = len(bucket)
 			}
@@ -467,37 +702,44 @@ func printBuckets(root string) {
 			if len(ent.Phones) > pW {
 				pW = len(ent.Phones)
 			}
+			if len(ent.Bucketed) > dW {
+				dW = len(ent.Bucketed)
+			}
 		}
 	}
-	fmt.Printf("%-*s  %-*s  %-*s  %-*s\n", bW, "Bucket", nW, "Name", eW, "Emails", pW, "Phones")
-	fmt.Printf("%s  %s  %s  %s\n", strings.Repeat("-", bW), strings.Repeat("-", nW), strings.Repeat("-", eW), strings.Repeat("-", pW))
+	fmt.Printf("%-*s  %-*s  %-*s  %-*s  %-*s  %s\n", bW, "Bucket", nW, "Name", eW, "Emails", pW, "Phones", dW, "Bucketed", "Reason")
+	fmt.Printf("%s  %s  %s  %s  %s  %s\n", strings.Repeat("-", bW), strings.Repeat("-", nW), strings.Repeat("-", eW), strings.Repeat("-", pW), strings.Repeat("-", dW), strings.Repeat("-", len("Reason")))
 	for _, r := range rows {
-		fmt.Printf("%-*s  %-*s  %-*s  %-*s\n", bW, r.Bucket, nW, r.Name, eW, r.Emails, pW, r.Phones)
+		fmt.Printf("%-*s  %-*s  %-*s  %-*s  %-*s  %s\n", bW, r.Bucket, nW, r.Name, eW, r.Emails, pW, r.Phones, dW, r.Bucketed, r.Reason)
 	}
 }
 
 func addEntry(client *radClient, d desiredEntry) {
 	ctx := context.Background()
 	card := vcard.Card{}
-	card.SetValue(vcard.FieldVersion, "4.0")
+	card.SetValue(vcard.FieldVersion, client.newCardVersion())
 	card.SetValue(vcard.FieldFormattedName, d.Name)
-	card.SetValue(vcard.FieldName, d.Name)
-	for _, em := range d.Emails {
-		if em == "" {
-			continue
-		}
-		card.Add(vcard.FieldEmail, &vcard.Field{Value: strings.ToLower(em)})
+	if err := setStructuredName(&card, d.Name, d.N); err != nil {
+		log.Fatalf("add: %v", err)
 	}
-	for _, n := range normalizeAndOrderPhones(d.Phones) {
-		card.Add(vcard.FieldTelephone, &vcard.Field{
-			Value:  n,
-			Params: map[string][]string{vcard.ParamType: {"cell"}},
-		})
+	setCardRegion(&card, d.Region)
+	emails, err := emailFieldsFromSpecs(d.Emails, nil)
+	if err != nil {
+		log.Fatalf("add: %v", err)
+	}
+	setEmails(&card, emails)
+	phones, err := phoneFieldsFromSpecs(d.Phones, nil, cardRegion(card))
+	if err != nil {
+		log.Fatalf("add: %v", err)
 	}
+	setPhones(&card, phones)
 	if d.Note != "" {
 		card.SetValue(vcard.FieldNote, d.Note)
 	}
 	ensureUID(&card)
+	if missing := missingRequired(card, ""); len(missing) > 0 {
+		log.Fatalf("add: rules require %s", strings.Join(missing, ", "))
+	}
 	href := fmt.Sprintf("%s%s.vcf", client.collectionURL(), randomID())
 	if err := client.put(ctx, cardRef{Href: href}, card); err != nil {
 		log.Fatalf("add: %v", err)
@@ -505,47 +747,66 @@ func addEntry(client *radClient, d desiredEntry) {
 	log.Printf("added %s", d.Name)
 }
 
-func updateEntry(client *radClient, name, newName string, emails, phones []string, note *string) {
+func updateEntry(client *radClient, name, newName, region, n string, emails, phones, demote []string, note *string) {
 	ctx := context.Background()
 	cards := mustFetch(client)
 	target := findByName(cards, name)
 	if target == nil {
 		log.Fatalf("update: %s not found", name)
 	}
+	setCardRegion(&target.Card, region)
+	if err := applyUpdate(&target.Card, newName, emails, phones, note); err != nil {
+		log.Fatalf("update: %v", err)
+	}
+	if n != "" {
+		if err := setStructuredName(&target.Card, target.Card.Value(vcard.FieldFormattedName), n); err != nil {
+			log.Fatalf("update: %v", err)
+		}
+	}
+	if len(demote) > 0 {
+		done := demoteEmails(&target.Card, demote)
+		if len(done) < len(demote) {
+			log.Printf("update: only demoted %d of %d email(s): %s", len(done), len(demote), strings.Join(done, ", "))
+		}
+	}
+	if missing := missingRequired(target.Card, ""); len(missing) > 0 {
+		log.Fatalf("update: rules require %s", strings.Join(missing, ", "))
+	}
+	if err := client.put(ctx, target.Ref, target.Card); err != nil {
+		log.Fatalf("update: %v", err)
+	}
+	log.Printf("updated %s", name)
+}
+
+// applyUpdate mutates the card with the non-empty update values; a nil note leaves NOTE untouched.
+func applyUpdate(card *vcard.Card, newName string, emails, phones []string, note *string) error {
 	if newName != "" {
-		target.Card.SetValue(vcard.FieldFormattedName, newName)
-		target.Card.SetValue(vcard.FieldName, newName)
+		card.SetValue(vcard.FieldFormattedName, newName)
+		setStructuredName(card, newName, "")
 	}
 	if emails != nil && len(emails) > 0 {
-		clearProps(&target.Card, vcard.FieldEmail)
-		for _, em := range emails {
-			if em == "" {
-				continue
-			}
-			target.Card.Add(vcard.FieldEmail, &vcard.Field{Value: strings.ToLower(em)})
+		fields, err := emailFieldsFromSpecs(emails, (*card)[vcard.FieldEmail])
+		if err != nil {
+			return err
 		}
+		setEmails(card, fields)
 	}
 	if phones != nil && len(phones) > 0 {
-		clearProps(&target.Card, vcard.FieldTelephone)
-		for _, n := range normalizeAndOrderPhones(phones) {
-			target.Card.Add(vcard.FieldTelephone, &vcard.Field{
-				Value:  n,
-				Params: map[string][]string{vcard.ParamType: {"cell"}},
-			})
+		fields, err := phoneFieldsFromSpecs(phones, (*card)[vcard.FieldTelephone], cardRegion(*card))
+		if err != nil {
+			return err
 		}
+		setPhones(card, fields)
 	}
 	if note != nil {
 		if *note == "" {
-			clearProps(&target.Card, vcard.FieldNote)
+			clearProps(card, vcard.FieldNote)
 		} else {
-			target.Card.SetValue(vcard.FieldNote, *note)
+			card.SetValue(vcard.FieldNote, *note)
 		}
 	}
-	ensureUID(&target.Card)
-	if err := client.put(ctx, target.Ref, target.Card); err != nil {
-		log.Fatalf("update: %v", err)
-	}
-	log.Printf("updated %s", name)
+	ensureUID(card)
+	return nil
 }
 
 func deleteEntry(client *radClient, name string, backupPath string) {
@@ -561,144 +822,261 @@ func deleteEntry(client *radClient, name string, backupPath string) {
 		fname = safeFileName(name) + ".vcf"
 		log.Printf("backup path not provided (--vcf). Saving to %s in current directory.", fname)
 	}
-	if err := os.WriteFile(fname, []byte(serializeCard(target.Card)), 0o644); err != nil {
-		log.Fatalf("backup write failed: %v", err)
-	}
-	if err := client.delete(ctx, target.Ref); err != nil {
+	if err := backupAndDelete(ctx, client, target, fname); err != nil {
 		log.Fatalf("delete: %v", err)
 	}
 	log.Printf("deleted %s (backup at %s)", name, fname)
 }
 
-func moveEntry(client *radClient, name string, bucket string, newName string) {
+// backupAndDelete writes the card to backupPath and only then removes it from the server.
+func backupAndDelete(ctx context.Context, client *radClient, target *cardData, backupPath string) error {
+	if err := os.WriteFile(backupPath, []byte(serializeCard(target.Card)), 0o644); err != nil {
+		return fmt.Errorf("backup write failed: %w", err)
+	}
+	if err := flushBucketStore(); err != nil {
+		return fmt.Errorf("backup write failed: %w", err)
+	}
+	return client.delete(ctx, target.Ref)
+}
+
+func moveEntry(client *radClient, name string, bucket string, newName string, reason string) {
 	ctx := context.Background()
 	cards := mustFetch(client)
 	target := findByName(cards, name)
 	if target == nil {
 		log.Fatalf("move: %s not found", name)
 	}
+	fname, err := moveCard(ctx, client, target, bucket, newName, reason)
+	if err != nil {
+		log.Fatalf("move: %v", err)
+	}
+	log.Printf("moved %s to %s", target.Card.Value(vcard.FieldFormattedName), fname)
+}
+
+// moveCard writes the card into UN_CONTACTS/<bucket>, stamped with its provenance, and
+// deletes it from the server. It returns the path of the bucket VCF.
+func moveCard(ctx context.Context, client *radClient, target *cardData, bucket string, newName string, reason string) (string, error) {
+	stampProvenance(&target.Card, reason, target.Ref.Href)
+	addCategories(&target.Card, bucket)
 	if newName != "" {
 		target.Card.SetValue(vcard.FieldFormattedName, newName)
 	}
-	destDir := filepath.Join(getenv("UN_CONTACTS", "/home/pi/data/smbfs/dada/un-contacts"), bucket)
-	_ = os.MkdirAll(destDir, 0o755)
-	fname := filepath.Join(destDir, safeFileName(target.Card.Value(vcard.FieldFormattedName))+".vcf")
-	if err := os.WriteFile(fname, []byte(serializeCard(target.Card)), 0o644); err != nil {
-		log.Fatalf("move backup failed: %v", err)
+	destDir := filepath.Join(bucketRoot(), bucket)
+	// never overwrite: bulk moves (triage, review, sync extras) often share names, and
+	// non-Latin names have no ASCII file name at all
+	fname := uniqueBucketPath(destDir, target.Card.Value(vcard.FieldFormattedName))
+	ensureUID(&target.Card)
+	setRevNow(&target.Card)
+	if err := writeVCF(fname, []vcard.Card{target.Card}); err != nil {
+		return "", fmt.Errorf("backup failed: %w", err)
+	}
+	// the bucket copy must be on disk before the server copy goes
+	if err := flushBucketStore(); err != nil {
+		return "", fmt.Errorf("backup failed: %w", err)
 	}
 	if err := client.delete(ctx, target.Ref); err != nil {
-		log.Fatalf("move delete failed: %v", err)
+		return fname, fmt.Errorf("delete failed: %w", err)
 	}
-	log.Printf("moved %s to %s", target.Card.Value(vcard.FieldFormattedName), fname)
+	return fname, nil
 }
 
-func restoreEntry(client *radClient, name string, bucket string, keepSource bool) {
-	root := getenv("UN_CONTACTS", "/home/pi/data/smbfs/dada/un-contacts")
-	path, card, err := findBucketCard(root, bucket, name)
-	if err != nil {
-		log.Fatalf("restore: %v", err)
+// restoreQuery selects bucket cards for `restore`; every field given must match.
+type restoreQuery struct {
+	Name, Phone, Email, UID, Path, Bucket string
+}
+
+func (q restoreQuery) empty() bool {
+	return q.Name == "" && q.Phone == "" && q.Email == "" && q.UID == "" && q.Path == "" && q.Bucket == ""
+}
+
+// restoreEntries restores the cards matching q. Several matches are all restored with
+// all, offered as a numbered choice on a terminal, and otherwise listed as an error.
+func restoreEntries(client *radClient, q restoreQuery, all bool, keepSource bool) {
+	root := bucketRoot()
+	matches := findBucketCards(root, q)
+	if len(matches) == 0 {
+		log.Fatalf("restore: no bucket card matches %s", q)
+	}
+	if len(matches) > 1 && !all {
+		matches = pickBucketCards(root, matches)
+	}
+	drop := map[string]map[int]bool{}
+	failed := 0
+	for _, bc := range matches {
+		if err := restoreCard(client, bc); err != nil {
+			log.Printf("restore %s: %v", bc.Path, err)
+			failed++
+			continue
+		}
+		if drop[bc.Path] == nil {
+			drop[bc.Path] = map[int]bool{}
+		}
+		drop[bc.Path][bc.Index] = true
+	}
+	// drop only the restored cards; other cards sharing a file stay in the bucket
+	if !keepSource {
+		rewriteBucketFiles("restore: remove from", drop, nil)
+	}
+	if len(matches) > 1 {
+		log.Printf("restore: %d card(s) restored, %d failed", len(matches)-failed, failed)
+	}
+	if failed > 0 {
+		closeBuckets()
+		os.Exit(1)
+	}
+}
+
+// restoreCard uploads one bucket card to the server, stripped of its bucket provenance.
+func restoreCard(client *radClient, bc bucketCard) error {
+	card := copyCard(bc.Card)
+
+	// Bring back the server-side name and href recorded when the card was bucketed.
+	originalFN, sourceHref := clearProvenance(&card)
+	removeCategories(&card, bc.Bucket)
+	if originalFN != "" {
+		card.SetValue(vcard.FieldFormattedName, originalFN)
 	}
 
 	// Normalize before upload.
 	fn := strings.TrimSpace(card.Value(vcard.FieldFormattedName))
 	if fn == "" {
-		fn = name
+		fn = strings.TrimSuffix(filepath.Base(bc.Path), filepath.Ext(bc.Path))
 		card.SetValue(vcard.FieldFormattedName, fn)
 	}
-	card.SetValue(vcard.FieldName, fn)
+	syncStructuredName(&card, true)
 	ensureUID(&card)
 
-	emails := dedupeLower(getValues(card, vcard.FieldEmail))
-	clearProps(&card, vcard.FieldEmail)
-	for _, em := range emails {
-		card.Add(vcard.FieldEmail, &vcard.Field{Value: em})
-	}
-
-	phones := normalizeAndOrderPhones(getValues(card, vcard.FieldTelephone))
-	clearProps(&card, vcard.FieldTelephone)
-	for _, p := range phones {
-		card.Add(vcard.FieldTelephone, &vcard.Field{
-			Value:  p,
-			Params: map[string][]string{vcard.ParamType: {"cell"}},
-		})
-	}
+	normalizeEmailsInCard(&card)
+	normalizePhonesInCard(&card)
 	setRevNow(&card)
 
 	ctx := context.Background()
 	href := fmt.Sprintf("%s%s.vcf", client.collectionURL(), randomID())
-	if err := client.put(ctx, cardRef{Href: href}, card); err != nil {
-		log.Fatalf("restore put failed: %v", err)
+	if sourceHref != "" && strings.Contains(sourceHref, "/"+client.collection+"/") {
+		// reuse the original href only when the server says it is free (404), and never
+		// overwrite a card that appeared there in between
+		if _, err := client.get(ctx, cardRef{Href: sourceHref}); errors.Is(err, errCardNotFound) {
+			err := client.create(ctx, cardRef{Href: sourceHref}, copyCard(card))
+			if err == nil {
+				log.Printf("restored %s from %s", fn, bc.Path)
+				return nil
+			}
+			if !errors.Is(err, errCardExists) {
+				return fmt.Errorf("put failed: %w", err)
+			}
+		}
 	}
-	if !keepSource {
-		_ = os.Remove(path)
+	if err := client.put(ctx, cardRef{Href: href}, card); err != nil {
+		return fmt.Errorf("put failed: %w", err)
 	}
-	log.Printf("restored %s from %s", fn, path)
+	log.Printf("restored %s from %s", fn, bc.Path)
+	return nil
 }
 
-func findBucketCard(root string, bucket string, name string) (string, vcard.Card, error) {
-	dir := filepath.Join(root, bucket)
-	key := norm(name)
-	matches := 0
-	var matchPath string
-	var matchCard vcard.Card
+// findBucketCards returns the bucket cards matching q, across all buckets unless
+// q.Bucket is set. Names match FN or X-DAV-ORIGINAL-FN; phones and emails match after
+// normalization; --path may be relative to the bucket root.
+func findBucketCards(root string, q restoreQuery) []bucketCard {
+	idx := bucketIndexFor(root)
+	keys := []string{}
+	if q.Name != "" {
+		keys = append(keys, "fn:"+norm(q.Name))
+	}
+	if q.Phone != "" {
+		n := normalizePhone(q.Phone)
+		if n == "" {
+			log.Fatalf("restore: %q is not a phone number", q.Phone)
+		}
+		keys = append(keys, "tel:"+n)
+	}
+	if q.Email != "" {
+		keys = append(keys, "email:"+emailKey(q.Email))
+	}
+	path := q.Path
+	if path != "" && !strings.HasPrefix(path, root) {
+		path = filepath.Join(root, path)
+	}
 
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
+	candidates := idx.cards()
+	if len(keys) > 0 {
+		candidates = idx.lookup(keys[0])
+	}
+	out := []bucketCard{}
+	for _, bc := range candidates {
+		if q.Bucket != "" && !strings.EqualFold(bc.Bucket, q.Bucket) {
+			continue
 		}
-		if info.IsDir() {
-			return nil
+		if path != "" && filepath.Clean(bc.Path) != filepath.Clean(path) {
+			continue
 		}
-		if strings.ToLower(filepath.Ext(path)) != ".vcf" {
-			return nil
+		if q.UID != "" && strings.TrimSpace(bc.Card.Value(vcard.FieldUID)) != q.UID {
+			continue
 		}
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil
+		if !hasKeys(idx, bc, keys[min(1, len(keys)):]) {
+			continue
 		}
-		dec := vcard.NewDecoder(strings.NewReader(string(data)))
-		for {
-			card, err := dec.Decode()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
+		out = append(out, bc)
+	}
+	return out
+}
+
+// hasKeys reports whether bc is among the index entries of every key.
+func hasKeys(idx *bucketIndex, bc bucketCard, keys []string) bool {
+	for _, k := range keys {
+		found := false
+		for _, other := range idx.lookup(k) {
+			if other.Path == bc.Path && other.Index == bc.Index {
+				found = true
 				break
 			}
-			fn := strings.TrimSpace(card.Value(vcard.FieldFormattedName))
-			if norm(fn) != key {
-				continue
-			}
-			matches++
-			matchPath = path
-			matchCard = card
 		}
-		return nil
-	})
-	if err != nil {
-		return "", vcard.Card{}, err
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// pickBucketCards lets the user choose among several matches on a terminal; without one
+// it lists them and exits.
+func pickBucketCards(root string, matches []bucketCard) []bucketCard {
+	rel := func(p string) string {
+		if r, err := filepath.Rel(root, p); err == nil {
+			return r
+		}
+		return p
 	}
-	if matches == 0 {
-		return "", vcard.Card{}, fmt.Errorf("%s not found in %s", name, dir)
+	fmt.Printf("%d bucket cards match:\n", len(matches))
+	for i, bc := range matches {
+		fmt.Printf("  %2d. [%s] %s  %s  %s  (%s)\n", i+1, bc.Bucket, bc.Card.Value(vcard.FieldFormattedName),
+			strings.Join(getValues(bc.Card, vcard.FieldTelephone), ", "), strings.Join(getValues(bc.Card, vcard.FieldEmail), ", "), rel(bc.Path))
 	}
-	if matches > 1 {
-		return "", vcard.Card{}, fmt.Errorf("%s matched %d vcards in %s; please dedupe first", name, matches, dir)
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		log.Fatalf("restore: %d matches; narrow with --bucket, --uid or --path, or pass --all", len(matches))
+	}
+	s := &reviewSession{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	for {
+		switch ans := s.askLine(fmt.Sprintf("Restore which? [1-%d, a=all, q=quit] ", len(matches))); strings.ToLower(ans) {
+		case "q", "":
+			log.Fatalf("restore: aborted, nothing restored")
+		case "a":
+			return matches
+		default:
+			if n, err := strconv.Atoi(ans); err == nil && n >= 1 && n <= len(matches) {
+				return matches[n-1 : n]
+			}
+		}
 	}
-	return matchPath, matchCard, nil
 }
 
-func dedupeLower(vals []string) []string {
-	seen := map[string]bool{}
-	out := []string{}
-	for _, v := range vals {
-		v = strings.ToLower(strings.TrimSpace(v))
-		if v == "" || seen[v] {
-			continue
+func (q restoreQuery) String() string {
+	parts := []string{}
+	for _, f := range []struct{ flag, v string }{{"name", q.Name}, {"phone", q.Phone}, {"email", q.Email}, {"uid", q.UID}, {"path", q.Path}, {"bucket", q.Bucket}} {
+		if f.v != "" {
+			parts = append(parts, fmt.Sprintf("--%s %q", f.flag, f.v))
 		}
-		seen[v] = true
-		out = append(out, v)
 	}
-	return out
+	return strings.Join(parts, " ")
 }
 
 // Sync workflow
@@ -709,7 +1087,6 @@ func runSync(source string, apply bool, touch bool) {
 		log.Fatalf("parse desired: %v", err)
 	}
 	client := newClient()
-	bucketRoot := getenv("UN_CONTACTS", "/home/pi/data/smbfs/dada/un-contacts")
 	ctx := context.Background()
 	refs, err := client.list(ctx)
 	if err != nil {
@@ -750,12 +1127,9 @@ func runSync(source string, apply bool, touch bool) {
 			continue
 		}
 		if apply {
-			destDir := filepath.Join(bucketRoot, "neutral")
-			_ = os.MkdirAll(destDir, 0o755)
-			fname := filepath.Join(destDir, safeFileName(cd.Card.Value(vcard.FieldFormattedName))+".vcf")
-			_ = os.WriteFile(fname, []byte(serializeCard(cd.Card)), 0o644)
-			if err := client.delete(ctx, cd.Ref); err != nil {
-				log.Printf("delete extra %s: %v", cd.Ref.Href, err)
+			extra := cd
+			if _, err := moveCard(ctx, client, &extra, "neutral", "", "not in sync source "+filepath.Base(source)); err != nil {
+				log.Printf("move extra %s: %v", cd.Ref.Href, err)
 			}
 		} else {
 			log.Printf("[dry-run] would remove extra %s", cd.Card.Value(vcard.FieldFormattedName))
@@ -770,6 +1144,9 @@ func runSync(source string, apply bool, touch bool) {
 		key := norm(d.Name)
 		if existing, ok := remote[key]; ok {
 			updated := applyDesired(&existing.Card, d, photoMap, enableGravatar, false)
+			if missing := missingRequired(existing.Card, ""); len(missing) > 0 {
+				log.Printf("[warn] %s missing required %s", d.Name, strings.Join(missing, ", "))
+			}
 			if updated && apply {
 				if err := client.put(ctx, existing.Ref, existing.Card); err != nil {
 					log.Printf("put %s: %v", existing.Ref.Href, err)
@@ -777,24 +1154,29 @@ func runSync(source string, apply bool, touch bool) {
 			}
 		} else {
 			card := vcard.Card{}
-			card.SetValue(vcard.FieldVersion, "4.0")
+			card.SetValue(vcard.FieldVersion, client.newCardVersion())
 			card.SetValue(vcard.FieldFormattedName, d.Name)
-			for _, em := range d.Emails {
-				if em == "" {
-					continue
-				}
-				card.Add(vcard.FieldEmail, &vcard.Field{Value: strings.ToLower(em)})
+			setStructuredName(&card, d.Name, "")
+			emails, err := emailFieldsFromSpecs(d.Emails, nil)
+			if err != nil {
+				log.Printf("skip %s: %v", d.Name, err)
+				continue
 			}
-			for _, n := range normalizeAndOrderPhones(d.Phones) {
-				card.Add(vcard.FieldTelephone, &vcard.Field{
-					Value:  n,
-					Params: map[string][]string{vcard.ParamType: {"cell"}},
-				})
+			setEmails(&card, emails)
+			phones, err := phoneFieldsFromSpecs(d.Phones, nil, cardRegion(card))
+			if err != nil {
+				log.Printf("skip %s: %v", d.Name, err)
+				continue
 			}
+			setPhones(&card, phones)
 			if d.Note != "" {
 				card.SetValue(vcard.FieldNote, d.Note)
 			}
 			ensureUID(&card)
+			if missing := missingRequired(card, ""); len(missing) > 0 {
+				log.Printf("skip %s: rules require %s", d.Name, strings.Join(missing, ", "))
+				continue
+			}
 			ref := cardRef{Href: fmt.Sprintf("%s%s.vcf", client.collectionURL(), randomID())}
 			if apply {
 				if err := client.put(ctx, ref, card); err != nil {
@@ -857,73 +1239,441 @@ func splitCSV(s string) []string {
 
 func norm(s string) string { return strings.ToLower(strings.TrimSpace(s)) }
 
-func normalizePhone(num string) string {
-	cleaned := strings.Map(func(r rune) rune {
+func normalizePhone(num string) string { return normalizePhoneIn(num, defaultRegion()) }
+
+// normalizePhoneIn formats num as spaced E.164 using the numbering plan of its country;
+// national numbers are read as dialled within region. Numbers that fail validation are
+// returned with punctuation stripped so they stay recognisable (see phoneProblem).
+func normalizePhoneIn(num string, region string) string {
+	if n, err := phone.Parse(num, region); err == nil {
+		return n.Format()
+	}
+	return strings.Map(func(r rune) rune {
 		if r >= '0' && r <= '9' || r == '+' {
 			return r
 		}
 		return -1
 	}, num)
-	if cleaned == "" {
-		return ""
+}
+
+// phoneProblem explains why num is not a possible number, or returns nil.
+func phoneProblem(num string, region string) error {
+	_, err := phone.Parse(num, region)
+	return err
+}
+
+// phoneLabels are the TEL labels from STANDARDS.md, in the order used to pick a field's label.
+var phoneLabels = []string{"fax", "pager", "cell", "work", "home", "other"}
+
+var phoneLabelAliases = map[string]string{"mobile": "cell", "landline": "home", "office": "work"}
+
+// parsePhoneSpec reads "number", "number:type" or "number:type:pref" (as used by --phones and
+// markdown tables) into a TEL field. Without a type the field is left untyped so
+// normalization can pick a default.
+func parsePhoneSpec(spec string) (*vcard.Field, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(strings.ToLower(spec), "tel:") {
+		spec = spec[len("tel:"):]
+	}
+	parts := strings.Split(spec, ":")
+	f := &vcard.Field{Value: strings.TrimSpace(parts[0]), Params: vcard.Params{}}
+	for _, p := range parts[1:] {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if alias, ok := phoneLabelAliases[p]; ok {
+			p = alias
+		}
+		switch {
+		case p == "":
+		case p == "pref":
+			f.Params.Set(vcard.ParamPreferred, "1")
+		case containsString(allowedPhoneLabels(), p):
+			f.Params.Add(vcard.ParamType, p)
+		default:
+			return nil, fmt.Errorf("unknown phone type %q in %q (use %s)", p, spec, strings.Join(allowedPhoneLabels(), "|"))
+		}
+	}
+	return f, nil
+}
+
+// phoneSpec renders a TEL field back into spec form; the default label is implied.
+func phoneSpec(f *vcard.Field) string {
+	s := f.Value
+	if l := phoneLabel(f); l != "" && l != defaultPhoneLabel() {
+		s += ":" + l
+	}
+	if isPreferred(f) {
+		s += ":pref"
+	}
+	return s
+}
+
+func phoneSpecs(c vcard.Card) []string {
+	out := []string{}
+	for _, f := range c[vcard.FieldTelephone] {
+		if f.Value != "" {
+			out = append(out, phoneSpec(f))
+		}
+	}
+	return out
+}
+
+// phoneFieldsFromSpecs builds TEL fields from specs. Numbers given without a type keep the
+// parameters of the matching number in existing, so re-listing a number never retypes it.
+func phoneFieldsFromSpecs(specs []string, existing []*vcard.Field, region string) ([]*vcard.Field, error) {
+	prev := map[string]*vcard.Field{}
+	for _, f := range existing {
+		prev[normalizePhoneIn(f.Value, region)] = f
+	}
+	out := []*vcard.Field{}
+	for _, spec := range specs {
+		f, err := parsePhoneSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		if f.Value == "" {
+			continue
+		}
+		if old, ok := prev[normalizePhoneIn(f.Value, region)]; ok && len(f.Params[vcard.ParamType]) == 0 {
+			pref := f.Params.Get(vcard.ParamPreferred)
+			f.Params = cloneParams(old.Params)
+			if pref != "" {
+				f.Params.Set(vcard.ParamPreferred, pref)
+			}
+		}
+		out = append(out, f)
 	}
-	digits := strings.TrimPrefix(cleaned, "+")
-	if strings.HasPrefix(cleaned, "+0") && len(digits) == 11 {
-		cleaned = "+91" + digits[1:]
-		digits = strings.TrimPrefix(cleaned, "+")
+	return out, nil
+}
+
+// setPhones replaces the card's TEL fields and normalizes them.
+func setPhones(card *vcard.Card, fields []*vcard.Field) {
+	clearProps(card, vcard.FieldTelephone)
+	for _, f := range fields {
+		card.Add(vcard.FieldTelephone, f)
 	}
-	if !strings.HasPrefix(cleaned, "+") {
-		if strings.HasPrefix(digits, "0") && len(digits) == 11 {
-			digits = digits[1:]
+	normalizePhonesInCard(card)
+}
+
+// normalizePhonesInCard formats, dedupes and orders TEL fields while keeping their
+// TYPE/PREF parameters, and enforces one PREF per label.
+func normalizePhonesInCard(card *vcard.Card) {
+	fields := normalizePhoneFields((*card)[vcard.FieldTelephone], cardRegion(*card), isV3(*card))
+	clearProps(card, vcard.FieldTelephone)
+	for _, f := range fields {
+		card.Add(vcard.FieldTelephone, f)
+	}
+}
+
+// normalizePhoneFields formats and dedupes TEL fields (duplicate numbers merge their types);
+// numbers from the home region go last unless the rules file turns that off. Untyped
+// numbers get the default label (cell), or other for fixed-line/toll-free ranges. Impossible numbers are kept (never silently dropped) but logged.
+func normalizePhoneFields(fields []*vcard.Field, region string, v3 bool) []*vcard.Field {
+	homeCode := phone.CallingCode(region)
+	intl := []*vcard.Field{}
+	home := []*vcard.Field{}
+	all := []*vcard.Field{}
+	byValue := map[string]*vcard.Field{}
+	for _, f := range fields {
+		parsed, err := phone.Parse(f.Value, region)
+		n := normalizePhoneIn(f.Value, region)
+		if n == "" {
+			continue
+		}
+		if prev, ok := byValue[n]; ok {
+			mergePhoneParams(prev.Params, f.Params)
+			continue
+		}
+		if err != nil {
+			log.Printf("[warn] phone %q: %v", f.Value, err)
+		}
+		nf := &vcard.Field{Value: n, Group: f.Group, Params: cloneParams(f.Params)}
+		if phoneLabel(nf) == "" {
+			label := defaultPhoneLabel()
+			if err == nil && (parsed.Type() == phone.Fixed || parsed.Type() == phone.TollFree) {
+				label = "other"
+			}
+			nf.Params.Add(vcard.ParamType, label)
 		}
-		if len(digits) == 10 {
-			cleaned = "+91" + digits
+		byValue[n] = nf
+		all = append(all, nf)
+		if err == nil && parsed.CountryCode == homeCode {
+			home = append(home, nf)
 		} else {
-			cleaned = "+" + digits
+			intl = append(intl, nf)
 		}
 	}
-	digits = strings.TrimPrefix(cleaned, "+")
-	if strings.HasPrefix(cleaned, "+91") && len(digits) == 12 {
-		return fmt.Sprintf("+91 %s %s", digits[2:7], digits[7:])
+	ordered := append(intl, home...)
+	if !internationalFirst() {
+		ordered = all
+	}
+	enforcePrimaryPerLabel(ordered, v3)
+	return ordered
+}
+
+// phoneLabel returns the STANDARDS.md label of a TEL field ("" when untyped).
+func phoneLabel(f *vcard.Field) string {
+	types := f.Params.Types()
+	for _, l := range phoneLabels {
+		if containsString(types, l) {
+			return l
+		}
 	}
-	if strings.HasPrefix(cleaned, "+1") && len(digits) == 11 {
-		return fmt.Sprintf("+1 %s %s %s", digits[0:3], digits[3:6], digits[6:])
+	for _, t := range types {
+		if t != "pref" && t != "voice" {
+			return "other"
+		}
 	}
-	return cleaned
+	return ""
 }
 
-func normalizeAndOrderPhones(nums []string) []string {
-	intl := []string{}
-	india := []string{}
-	seen := map[string]bool{}
-	for _, ph := range nums {
-		n := normalizePhone(ph)
-		if n == "" || seen[n] {
+func isPreferred(f *vcard.Field) bool {
+	return f.Params.Get(vcard.ParamPreferred) != "" || f.Params.HasType("pref")
+}
+
+// enforcePrimaryPerLabel keeps a single preferred number per label (see markPrimary).
+func enforcePrimaryPerLabel(fields []*vcard.Field, v3 bool) {
+	byLabel := map[string][]*vcard.Field{}
+	labels := []string{}
+	for _, f := range fields {
+		l := phoneLabel(f)
+		if _, ok := byLabel[l]; !ok {
+			labels = append(labels, l)
+		}
+		byLabel[l] = append(byLabel[l], f)
+	}
+	for _, l := range labels {
+		markPrimary(byLabel[l], v3)
+	}
+}
+
+// markPrimary leaves exactly one preferred field in group (the best-ranked existing
+// preference, else the first field when there are several) and returns its index.
+// vCard 3 cards use TYPE=pref, later versions PREF=1.
+func markPrimary(group []*vcard.Field, v3 bool) int {
+	primary := -1
+	best := 101
+	for i, f := range group {
+		if !isPreferred(f) {
+			continue
+		}
+		rank := 1
+		if p := f.Params.Get(vcard.ParamPreferred); p != "" {
+			fmt.Sscanf(p, "%d", &rank)
+		}
+		if rank < best {
+			best, primary = rank, i
+		}
+	}
+	if primary < 0 && len(group) > 1 {
+		primary = 0
+	}
+	for i, f := range group {
+		delete(f.Params, vcard.ParamPreferred)
+		removeType(f.Params, "pref")
+		if i != primary {
 			continue
 		}
-		seen[n] = true
-		if strings.HasPrefix(n, "+91") {
-			india = append(india, n)
+		if v3 {
+			f.Params.Add(vcard.ParamType, "pref")
 		} else {
-			intl = append(intl, n)
+			f.Params.Set(vcard.ParamPreferred, "1")
 		}
 	}
-	return append(intl, india...)
+	return primary
 }
 
-func normalizePhonesInCard(card *vcard.Card) {
-	nums := []string{}
-	for _, tel := range (*card)[vcard.FieldTelephone] {
-		nums = append(nums, tel.Value)
+// emailLabels are the EMAIL types accepted in specs.
+var emailLabels = []string{"home", "work", "other"}
+
+// parseEmailSpec reads "addr", "addr:type" or "addr:type:pref" into an EMAIL field.
+func parseEmailSpec(spec string) (*vcard.Field, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(strings.ToLower(spec), "mailto:") {
+		spec = spec[len("mailto:"):]
+	}
+	parts := strings.Split(spec, ":")
+	f := &vcard.Field{Value: strings.TrimSpace(parts[0]), Params: vcard.Params{}}
+	for _, p := range parts[1:] {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "":
+		case p == "pref":
+			f.Params.Set(vcard.ParamPreferred, "1")
+		case containsString(allowedEmailLabels(), p):
+			f.Params.Add(vcard.ParamType, p)
+		default:
+			return nil, fmt.Errorf("unknown email type %q in %q (use %s)", p, spec, strings.Join(allowedEmailLabels(), "|"))
+		}
+	}
+	return f, nil
+}
+
+// emailFieldsFromSpecs builds EMAIL fields from specs; addresses given without a type keep
+// the parameters of the same address in existing. The first address listed is the primary.
+func emailFieldsFromSpecs(specs []string, existing []*vcard.Field) ([]*vcard.Field, error) {
+	prev := map[string]*vcard.Field{}
+	for _, f := range existing {
+		prev[emailKey(f.Value)] = f
 	}
-	ordered := normalizeAndOrderPhones(nums)
-	clearProps(card, vcard.FieldTelephone)
-	for _, num := range ordered {
-		card.Add(vcard.FieldTelephone, &vcard.Field{
-			Value:  num,
-			Params: map[string][]string{vcard.ParamType: {"cell"}},
-		})
+	out := []*vcard.Field{}
+	for _, spec := range specs {
+		f, err := parseEmailSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		if f.Value == "" {
+			continue
+		}
+		if old, ok := prev[emailKey(f.Value)]; ok && len(f.Params[vcard.ParamType]) == 0 {
+			pref := f.Params.Get(vcard.ParamPreferred)
+			f.Params = cloneParams(old.Params)
+			delete(f.Params, vcard.ParamPreferred)
+			removeType(f.Params, "pref")
+			if pref != "" {
+				f.Params.Set(vcard.ParamPreferred, pref)
+			}
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// setEmails replaces the card's EMAIL fields and normalizes them.
+func setEmails(card *vcard.Card, fields []*vcard.Field) {
+	clearProps(card, vcard.FieldEmail)
+	for _, f := range fields {
+		card.Add(vcard.FieldEmail, f)
+	}
+	normalizeEmailsInCard(card)
+}
+
+// normalizeEmailsInCard lowercases, validates and dedupes EMAIL fields, keeping TYPE/PREF,
+// and puts the primary (preferred) address first.
+func normalizeEmailsInCard(card *vcard.Card) {
+	fields := normalizeEmailFields((*card)[vcard.FieldEmail], isV3(*card))
+	clearProps(card, vcard.FieldEmail)
+	for _, f := range fields {
+		card.Add(vcard.FieldEmail, f)
+	}
+}
+
+// normalizeEmailFields is the field-level worker of normalizeEmailsInCard. Invalid addresses
+// are logged and kept lowercased rather than dropped.
+func normalizeEmailFields(fields []*vcard.Field, v3 bool) []*vcard.Field {
+	out := []*vcard.Field{}
+	byKey := map[string]*vcard.Field{}
+	for _, f := range fields {
+		if strings.TrimSpace(f.Value) == "" {
+			continue
+		}
+		v, err := email.Normalize(f.Value)
+		if err != nil {
+			log.Printf("[warn] email %q: %v", f.Value, err)
+			v = norm(f.Value)
+		}
+		// only the same address is folded; provider aliases (emailKey) are kept as stored
+		key := v
+		if prev, ok := byKey[key]; ok {
+			mergePhoneParams(prev.Params, f.Params)
+			continue
+		}
+		nf := &vcard.Field{Value: v, Group: f.Group, Params: cloneParams(f.Params)}
+		byKey[key] = nf
+		out = append(out, nf)
+	}
+	if p := markPrimary(out, v3); p > 0 {
+		primary := out[p]
+		out = append(out[:p:p], out[p+1:]...)
+		out = append([]*vcard.Field{primary}, out...)
+	}
+	return out
+}
+
+// emailKey identifies an address for duplicate detection. With EMAIL_ALIAS_DEDUPE=1 provider
+// aliases fold together (j.doe+x@gmail.com == jdoe@gmail.com); stored values are unchanged.
+func emailKey(addr string) string {
+	if aliasDedupe() {
+		return email.Canonical(addr)
+	}
+	if n, err := email.Normalize(addr); err == nil {
+		return n
+	}
+	return norm(addr)
+}
+
+// demoteEmails moves the given addresses from EMAIL into NOTE ("Legacy email: ...") so stale
+// addresses stay searchable without being offered for sending. It returns the addresses actually demoted.
+func demoteEmails(card *vcard.Card, addrs []string) []string {
+	drop := map[string]bool{}
+	for _, a := range addrs {
+		drop[emailKey(a)] = true
+	}
+	kept := []*vcard.Field{}
+	demoted := []string{}
+	for _, f := range (*card)[vcard.FieldEmail] {
+		if drop[emailKey(f.Value)] {
+			demoted = append(demoted, f.Value)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	if len(demoted) == 0 {
+		return nil
+	}
+	setEmails(card, kept)
+	line := "Legacy email: " + strings.Join(demoted, ", ")
+	if note := strings.TrimSpace(card.Value(vcard.FieldNote)); note != "" {
+		line = note + "\n" + line
+	}
+	card.SetValue(vcard.FieldNote, line)
+	return demoted
+}
+
+func mergePhoneParams(dst, src vcard.Params) {
+	for _, t := range src[vcard.ParamType] {
+		if !dst.HasType(t) {
+			dst.Add(vcard.ParamType, strings.ToLower(t))
+		}
+	}
+	if p := src.Get(vcard.ParamPreferred); p != "" && dst.Get(vcard.ParamPreferred) == "" {
+		dst.Set(vcard.ParamPreferred, p)
+	}
+}
+
+func removeType(p vcard.Params, t string) {
+	kept := []string{}
+	for _, v := range p[vcard.ParamType] {
+		if !strings.EqualFold(v, t) {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		delete(p, vcard.ParamType)
+		return
+	}
+	p[vcard.ParamType] = kept
+}
+
+func cloneParams(p vcard.Params) vcard.Params {
+	out := vcard.Params{}
+	for k, v := range p {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+func isV3(c vcard.Card) bool {
+	v := c.Value(vcard.FieldVersion)
+	return strings.HasPrefix(v, "3") || strings.HasPrefix(v, "2")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
 	}
+	return false
 }
 
 func getValues(c vcard.Card, field string) []string {
@@ -941,10 +1691,7 @@ func clearProps(c *vcard.Card, field string) { delete(*c, field) }
 func serializeCard(card vcard.Card) string {
 	ensureUID(&card)
 	setRevNow(&card)
-	var b strings.Builder
-	enc := vcard.NewEncoder(&b)
-	_ = enc.Encode(card)
-	return b.String()
+	return encodeCard(card)
 }
 
 func randomID() string {
@@ -986,7 +1733,7 @@ func refreshUIDs(apply bool) {
 	for _, cd := range cards {
 		newCard := cd.Card
 		newCard.SetValue(vcard.FieldUID, fmt.Sprintf("uid-%s", randomID()))
-		newCard.SetValue(vcard.FieldName, newCard.Value(vcard.FieldFormattedName))
+		syncStructuredName(&newCard, true)
 		setRevNow(&newCard)
 		newHref := fmt.Sprintf("%s%s.vcf", client.collectionURL(), randomID())
 		if apply {
@@ -1101,52 +1848,29 @@ func fetchGravatar(email string) (string, bool) {
 }
 
 type bucketEntry struct {
-	Name   string
-	Emails string
-	Phones string
-	Path   string
+	Name     string
+	Emails   string
+	Phones   string
+	Path     string
+	Bucketed string // date from X-DAV-BUCKETED-AT
+	Reason   string // X-DAV-BUCKET-REASON
+	Original string // X-DAV-ORIGINAL-FN, when different from Name
 }
 
 func listBucketEntries(root string) map[string][]bucketEntry {
 	res := map[string][]bucketEntry{}
-	seen := map[string]string{} // norm name -> path kept
-	_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if info.IsDir() {
-			return nil
-		}
-		if filepath.Ext(path) != ".vcf" {
-			return nil
-		}
-		bucket := filepath.Base(filepath.Dir(path))
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil
-		}
-		dec := vcard.NewDecoder(strings.NewReader(string(data)))
-		for {
-			card, err := dec.Decode()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				break
-			}
-			name := card.Value(vcard.FieldFormattedName)
-			key := norm(name)
-			if prev, ok := seen[key]; ok && prev != path {
-				_ = os.Remove(path)
-				break
-			}
-			seen[key] = path
-			emails := strings.Join(getValues(card, vcard.FieldEmail), ", ")
-			phones := strings.Join(getValues(card, vcard.FieldTelephone), ", ")
-			res[bucket] = append(res[bucket], bucketEntry{Name: name, Emails: emails, Phones: phones, Path: path})
-		}
-		return nil
-	})
+	for _, bc := range loadBucketCards(root) {
+		card := bc.Card
+		name := card.Value(vcard.FieldFormattedName)
+		emails := strings.Join(getValues(card, vcard.FieldEmail), ", ")
+		phones := strings.Join(getValues(card, vcard.FieldTelephone), ", ")
+		ent := bucketEntry{Name: name, Emails: emails, Phones: phones, Path: bc.Path,
+			Bucketed: bucketedDate(card), Reason: card.Value(fieldBucketReason)}
+		if orig := card.Value(fieldOriginalFN); orig != "" && orig != name {
+			ent.Original = orig
+		}
+		res[bc.Bucket] = append(res[bc.Bucket], ent)
+	}
 	return res
 }
 
@@ -1158,7 +1882,7 @@ func writeTable(path string, cards []cardData) {
 	for _, c := range cards {
 		name := c.Card.Value(vcard.FieldFormattedName)
 		emails := strings.Join(getValues(c.Card, vcard.FieldEmail), ", ")
-		phones := strings.Join(getValues(c.Card, vcard.FieldTelephone), ", ")
+		phones := strings.Join(phoneSpecs(c.Card), ", ")
 		note := ""
 		if v := c.Card.Value(vcard.FieldNote); v != "" {
 			note = v
@@ -1187,67 +1911,216 @@ func findByName(cards []cardData, name string) *cardData {
 	return nil
 }
 
-func normalizeVCFPhones(path string) bool {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return false
+// samePerson reports whether two cards of one VCF describe the same person: equal
+// display names, or a shared email/phone when at most one of them has a name.
+func samePerson(a, b vcard.Card) bool {
+	fa, fb := norm(a.Value(vcard.FieldFormattedName)), norm(b.Value(vcard.FieldFormattedName))
+	if fa != "" && fb != "" {
+		return fa == fb
 	}
-	dec := vcard.NewDecoder(strings.NewReader(string(data)))
-	var cards []vcard.Card
-	for {
-		c, err := dec.Decode()
-		if err == io.EOF {
-			break
+	keys := map[string]bool{}
+	for _, k := range contactKeys(a) {
+		keys[k] = true
+	}
+	for _, k := range contactKeys(b) {
+		if keys[k] {
+			return true
 		}
-		if err != nil {
-			break
+	}
+	return false
+}
+
+// personClusters partitions the cards of one file into people (transitively samePerson),
+// in file order.
+func personClusters(cards []vcard.Card) [][]int {
+	parent := make([]int, len(cards))
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range cards {
+		parent[i] = i
+		for j := 0; j < i; j++ {
+			if samePerson(cards[i], cards[j]) {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+	clusters := [][]int{}
+	index := map[int]int{}
+	for i := range cards {
+		root := find(i)
+		k, ok := index[root]
+		if !ok {
+			k = len(clusters)
+			index[root] = k
+			clusters = append(clusters, nil)
 		}
-		cards = append(cards, c)
+		clusters[k] = append(clusters[k], i)
+	}
+	return clusters
+}
+
+// normalizeVCFFile normalizes one bucket VCF without losing data: cards of the same person
+// are merged (all fields, via mergeCards), unrelated people are split into their own
+// legibly named files next to it, and phones/N are normalized. It returns a line per
+// file written (or, without apply, that would be written).
+func normalizeVCFFile(path string, apply bool) []string {
+	cards, err := readVCF(path)
+	if err != nil {
+		log.Printf("[warn] %s: %v; left untouched", path, err)
+		return nil
 	}
 	if len(cards) == 0 {
-		return false
+		return nil
 	}
-	// gather all phones from all cards
-	allNums := []string{}
-	for _, c := range cards {
-		for _, tel := range c[vcard.FieldTelephone] {
-			allNums = append(allNums, tel.Value)
-		}
-	}
-	primary := cards[0]
-	primary.SetValue(vcard.FieldName, primary.Value(vcard.FieldFormattedName))
-	// normalize combined set
-	ordered := normalizeAndOrderPhones(allNums)
-	clearProps(&primary, vcard.FieldTelephone)
-	for _, num := range ordered {
-		primary.Add(vcard.FieldTelephone, &vcard.Field{
-			Value:  num,
-			Params: map[string][]string{vcard.ParamType: {"cell"}},
-		})
+	people := []vcard.Card{}
+	changed := false
+	merges := []string{}
+	for _, cluster := range personClusters(cards) {
+		person := copyCard(cards[cluster[0]])
+		for _, i := range cluster[1:] {
+			mergeCards(&person, cards[i])
+		}
+		normalizePhonesInCard(&person)
+		syncStructuredName(&person, true)
+		if len(cluster) > 1 {
+			merges = append(merges, fmt.Sprintf("%s (%d cards)", person.Value(vcard.FieldFormattedName), len(cluster)))
+		}
+		if len(cluster) > 1 || encodeCard(person) != encodeCard(cards[cluster[0]]) {
+			setRevNow(&person)
+			changed = true
+		}
+		people = append(people, person)
+	}
+	prefix := ""
+	if !apply {
+		prefix = "[dry-run] "
+	}
+	out := []string{}
+	if !changed && len(people) == 1 {
+		return out
+	}
+	line := prefix + "rewrite " + path
+	if len(merges) > 0 {
+		line += ": merged " + strings.Join(merges, ", ")
+	}
+	out = append(out, line)
+	// split files first: the original is only cut down once every person is on disk
+	written := []string{}
+	for _, person := range people[1:] {
+		p := uniqueBucketPath(filepath.Dir(path), person.Value(vcard.FieldFormattedName))
+		out = append(out, fmt.Sprintf("%ssplit %s -> %s", prefix, person.Value(vcard.FieldFormattedName), p))
+		if !apply {
+			continue
+		}
+		if err := writeVCF(p, []vcard.Card{person}); err != nil {
+			log.Printf("clean-buckets: write %s: %v; %s left untouched", p, err, path)
+			for _, w := range written {
+				logBucketErr("clean-buckets: remove", w, storeRemove(w))
+			}
+			return out
+		}
+		written = append(written, p)
+	}
+	if apply {
+		logBucketErr("clean-buckets: write", path, writeVCF(path, people[:1]))
 	}
-	return os.WriteFile(path, []byte(serializeCard(primary)), 0o644) == nil
+	return out
 }
 
-// cleanBuckets normalizes phone values/order in bucket VCFs; reports missing phones.
-// It does not delete entries; apply=false is dry-run.
+// cleanBuckets normalizes bucket VCFs (see normalizeVCFFile) and reports cards missing
+// the fields the rules file requires for their bucket (a phone by default) as well as
+// every file it rewrites. It does not delete entries; apply=false is dry-run.
 func cleanBuckets(apply bool) {
-	root := getenv("UN_CONTACTS", "/home/pi/data/smbfs/dada/un-contacts")
-	entries := listBucketEntries(root)
-	fixed := 0
-	for bucket, list := range entries {
-		for _, ent := range list {
-			if strings.TrimSpace(ent.Phones) == "" {
-				log.Printf("[warn] %s missing phone: %s (%s)", bucket, ent.Name, ent.Path)
+	root := bucketRoot()
+	rewritten := 0
+	for _, bucket := range bucketNames(root) {
+		for _, path := range bucketVCFs(filepath.Join(root, bucket)) {
+			cards, _ := readVCF(path)
+			for _, card := range cards {
+				name := card.Value(vcard.FieldFormattedName)
+				if missing := missingRequired(card, bucket); len(missing) > 0 {
+					log.Printf("[warn] %s missing %s: %s (%s)", bucket, strings.Join(missing, ", "), name, path)
+				}
+				for _, ph := range getValues(card, vcard.FieldTelephone) {
+					if err := phoneProblem(ph, cardRegion(card)); err != nil {
+						log.Printf("[warn] %s invalid phone %q: %s (%v)", bucket, ph, name, err)
+					}
+				}
 			}
-			if !apply || ent.Path == "" {
-				continue
+			for _, line := range normalizeVCFFile(path, apply) {
+				log.Print(line)
+				rewritten++
+			}
+		}
+	}
+	log.Printf("clean-buckets: %d file(s) rewritten. apply=%v", rewritten, apply)
+}
+
+// mergeCards folds src into dst: repeatable properties (TEL, EMAIL, ADR, URL, ...) are
+// unioned, distinct notes are appended and single-valued properties are only filled when
+// dst has none. Identity fields (UID, REV, VERSION, FN, N) always stay with dst.
+func mergeCards(dst *vcard.Card, src vcard.Card) {
+	if *dst == nil {
+		*dst = vcard.Card{}
+	}
+	for field, fields := range src {
+		switch field {
+		case vcard.FieldVersion, vcard.FieldUID, vcard.FieldRevision, vcard.FieldFormattedName,
+			vcard.FieldName, vcard.FieldProductID:
+			continue
+		case vcard.FieldNote:
+			have := (*dst)[vcard.FieldNote]
+			for _, f := range fields {
+				v := strings.TrimSpace(f.Value)
+				if v == "" {
+					continue
+				}
+				if len(have) == 0 {
+					dst.SetValue(vcard.FieldNote, v)
+					have = (*dst)[vcard.FieldNote]
+					continue
+				}
+				if !strings.Contains(have[0].Value, v) {
+					have[0].Value = strings.TrimSpace(have[0].Value + "\n" + v)
+				}
+			}
+			continue
+		case vcard.FieldTelephone, vcard.FieldEmail:
+			// the normalizers below dedupe values and merge their TYPE/PREF.
+			for _, f := range fields {
+				dst.Add(field, f)
 			}
-			if normalizeVCFPhones(ent.Path) {
-				fixed++
+			continue
+		case vcard.FieldBirthday, vcard.FieldAnniversary, vcard.FieldGender, vcard.FieldKind, vcard.FieldPhoto:
+			if len((*dst)[field]) == 0 {
+				(*dst)[field] = fields
+			}
+			continue
+		}
+		seen := map[string]bool{}
+		for _, f := range (*dst)[field] {
+			seen[mergeKey(field, f.Value)] = true
+		}
+		for _, f := range fields {
+			k := mergeKey(field, f.Value)
+			if k == "" || seen[k] {
+				continue
 			}
+			seen[k] = true
+			dst.Add(field, f)
 		}
 	}
-	log.Printf("clean-buckets: normalized %d file(s). apply=%v", fixed, apply)
+	normalizeEmailsInCard(dst)
+	normalizePhonesInCard(dst)
+}
+
+func mergeKey(field, value string) string {
+	return strings.TrimSpace(value)
 }
 
 func dedupeByName(ctx context.Context, client *radClient, cards []cardData, apply bool) []cardData {
@@ -1283,25 +2156,20 @@ func applyDesired(card *vcard.Card, d desiredEntry, photos map[string]string, en
 		changed = true
 	}
 	// keep N aligned to FN
-	if name := card.Value(vcard.FieldName); strings.TrimSpace(name) != d.Name {
-		card.SetValue(vcard.FieldName, d.Name)
+	if syncStructuredName(card, true) {
 		changed = true
 	}
-	// emails
-	clearProps(card, vcard.FieldEmail)
-	for _, em := range d.Emails {
-		if em == "" {
-			continue
-		}
-		card.Add(vcard.FieldEmail, &vcard.Field{Value: strings.ToLower(em)})
+	// emails (types of addresses already on the card carry over)
+	if emails, err := emailFieldsFromSpecs(d.Emails, (*card)[vcard.FieldEmail]); err != nil {
+		log.Printf("%s: %v", d.Name, err)
+	} else {
+		setEmails(card, emails)
 	}
-	// phones
-	clearProps(card, vcard.FieldTelephone)
-	for _, n := range normalizeAndOrderPhones(d.Phones) {
-		card.Add(vcard.FieldTelephone, &vcard.Field{
-			Value:  n,
-			Params: map[string][]string{vcard.ParamType: {"cell"}},
-		})
+	// phones (types/PREF of numbers already on the card carry over)
+	if phones, err := phoneFieldsFromSpecs(d.Phones, (*card)[vcard.FieldTelephone], cardRegion(*card)); err != nil {
+		log.Printf("%s: %v", d.Name, err)
+	} else {
+		setPhones(card, phones)
 	}
 	// note
 	if d.Note != "" {
@@ -1345,23 +2213,20 @@ func loadDotEnv() {
 	}
 }
 
-// fixNames sets structured N to match FN for every server contact.
-// This helps Android/WhatsApp pick up renamed contacts consistently.
-func fixNames(apply bool) {
+// fixNames derives structured N from FN for every server contact whose N is missing or no
+// longer matches FN; structured also splits legacy one-component N values.
+// This helps Android/WhatsApp pick up renamed contacts and sort by family name.
+func fixNames(apply, structured bool) {
 	client := newClient()
 	ctx := context.Background()
 	cards := mustFetch(client)
 	updated := 0
 	for _, cd := range cards {
 		fn := strings.TrimSpace(cd.Card.Value(vcard.FieldFormattedName))
-		if fn == "" {
-			continue
-		}
-		n := strings.TrimSpace(cd.Card.Value(vcard.FieldName))
-		if n == fn {
+		before := cd.Card.Value(vcard.FieldName)
+		if !syncStructuredName(&cd.Card, structured) {
 			continue
 		}
-		cd.Card.SetValue(vcard.FieldName, fn)
 		setRevNow(&cd.Card)
 		updated++
 		if apply {
@@ -1369,7 +2234,7 @@ func fixNames(apply bool) {
 				log.Printf("fix-names put %s: %v", cd.Ref.Href, err)
 			}
 		} else {
-			log.Printf("[dry-run] would set N to FN for %s", fn)
+			log.Printf("[dry-run] %s: N %q -> %q", fn, before, cd.Card.Value(vcard.FieldName))
 		}
 	}
 	log.Printf("fix-names updated %d contact(s). apply=%v", updated, apply)
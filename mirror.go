@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// mirrorStateFile sits in each bucket directory and remembers, per UID, what was last
+// synced so deletions on either side can be told apart from additions on the other.
+const mirrorStateFile = ".mirror.json"
+
+type mirrorState map[string]mirrorEntry
+
+type mirrorEntry struct {
+	Href string `json:"href"`
+	ETag string `json:"etag"`
+	Hash string `json:"hash"` // of the local card as last synced
+}
+
+// withCollection returns a client for another collection on the same server.
+func (c *radClient) withCollection(collection string) *radClient {
+	cp := *c
+	cp.collection = strings.Trim(collection, "/")
+	return &cp
+}
+
+// mkAddressBook creates the client's collection as a CardDAV address book (extended
+// MKCOL); an existing collection is not an error.
+func (c *radClient) mkAddressBook(ctx context.Context, displayName string) error {
+	body := `<?xml version="1.0"?>
+<d:mkcol xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+  <d:set><d:prop>
+    <d:resourcetype><d:collection/><card:addressbook/></d:resourcetype>
+    <d:displayname>` + xmlEscape(displayName) + `</d:displayname>
+  </d:prop></d:set>
+</d:mkcol>`
+	req, _ := http.NewRequestWithContext(ctx, "MKCOL", c.collectionURL(), strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	req.SetBasicAuth(c.user, c.pass)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed || (resp.StatusCode == http.StatusConflict && c.exists(ctx)) {
+		return nil // already there
+	}
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("mkcol status %d: %s", resp.StatusCode, string(b))
+	}
+	return nil
+}
+
+func (c *radClient) exists(ctx context.Context) bool {
+	_, err := c.list(ctx)
+	return err == nil
+}
+
+func xmlEscape(s string) string {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+	return r.Replace(s)
+}
+
+// bucketCollection is the collection mirroring bucket: BUCKET_COLLECTION_PREFIX + bucket,
+// by default a sibling of RADICALE_COLLECTION named un-<bucket>.
+func bucketCollection(client *radClient, bucket string) string {
+	prefix := getenv("BUCKET_COLLECTION_PREFIX", path.Dir(client.collection)+"/un-")
+	return strings.Trim(prefix, "/") + safeFileName(bucket) // URL-safe
+}
+
+func cardHash(card vcard.Card) string {
+	sum := sha256.Sum256([]byte(encodeCard(card)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func loadMirrorState(dir string) mirrorState {
+	st := mirrorState{}
+	data, err := storeRead(filepath.Join(dir, mirrorStateFile))
+	if err != nil {
+		return st
+	}
+	_ = json.Unmarshal(data, &st)
+	return st
+}
+
+func saveMirrorState(dir string, st mirrorState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storeWrite(filepath.Join(dir, mirrorStateFile), data)
+}
+
+// localCard locates one card inside a bucket directory.
+type localCard struct {
+	Path  string
+	Index int
+	Card  vcard.Card
+}
+
+// mirrorBuckets two-way syncs every bucket (or just only) with its collection.
+func mirrorBuckets(only string, apply bool) {
+	client := newClient()
+	root := bucketRoot()
+	buckets := bucketNames(root)
+	if only != "" {
+		buckets = []string{only}
+	}
+	for _, b := range buckets {
+		if err := mirrorBucket(client, root, b, apply); err != nil {
+			log.Printf("mirror %s: %v", b, err)
+		}
+	}
+}
+
+func mirrorBucket(client *radClient, root, bucket string, apply bool) error {
+	ctx := context.Background()
+	dir := filepath.Join(root, bucket)
+	remote := client.withCollection(bucketCollection(client, bucket))
+	prefix := fmt.Sprintf("[%s]", bucket)
+	if !apply {
+		prefix = "[dry-run] " + prefix
+	}
+
+	// local cards by UID; cards without one get a UID written back on apply
+	files := map[string][]vcard.Card{}
+	local := map[string]localCard{}
+	for _, p := range bucketVCFs(dir) {
+		cards, err := readVCF(p)
+		if err != nil {
+			// an unreadable file would look like local deletions; don't touch the remote
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		dirty := false
+		for i := range cards {
+			if strings.TrimSpace(cards[i].Value(vcard.FieldUID)) == "" {
+				ensureUID(&cards[i])
+				dirty = true
+			}
+			local[cards[i].Value(vcard.FieldUID)] = localCard{Path: p, Index: i, Card: cards[i]}
+		}
+		files[p] = cards
+		if dirty && apply {
+			if err := writeVCF(p, cards); err != nil {
+				return err
+			}
+		}
+	}
+
+	// remote cards by UID
+	if apply {
+		if err := remote.mkAddressBook(ctx, "Un-contacts: "+bucket); err != nil {
+			return err
+		}
+	}
+	remoteCards := map[string]cardData{}
+	if refs, err := remote.list(ctx); err == nil {
+		for _, ref := range refs {
+			cd, err := remote.get(ctx, ref)
+			if err != nil {
+				// a card we cannot read would look deleted on the device; skip the bucket
+				return fmt.Errorf("get %s: %v", ref.Href, err)
+			}
+			remoteCards[cd.Card.Value(vcard.FieldUID)] = cd
+		}
+	} else if apply {
+		return err
+	} else {
+		log.Printf("%s would create collection %s", prefix, remote.collectionURL())
+	}
+
+	state := loadMirrorState(dir)
+	uids := map[string]bool{}
+	for u := range local {
+		uids[u] = true
+	}
+	for u := range remoteCards {
+		uids[u] = true
+	}
+	for u := range state {
+		uids[u] = true
+	}
+	keys := []string{}
+	for u := range uids {
+		keys = append(keys, u)
+	}
+	sort.Strings(keys)
+
+	var pushed, pulled, delRemote, delLocal int
+	// cards deleted on a device go to .trash like dedupe/expire losers, not straight away
+	stamp := time.Now().Format(trashStampLayout)
+	quarantine := filepath.Join(root, trashDir, stamp)
+	quarantined := []string{}
+	push := func(lc localCard, ref cardRef) {
+		pushed++
+		log.Printf("%s push %s", prefix, lc.Card.Value(vcard.FieldFormattedName))
+		if apply {
+			if ref.Href == "" {
+				ref.Href = remote.collectionURL() + safeFileName(lc.Card.Value(vcard.FieldUID)) + ".vcf"
+			}
+			// put stamps REV/UID on the card it is given; keep the local copy as on disk
+			if err := remote.put(ctx, ref, copyCard(lc.Card)); err != nil {
+				log.Printf("%s push %s: %v", prefix, ref.Href, err)
+			}
+		}
+	}
+	pull := func(cd cardData, lc *localCard) {
+		pulled++
+		log.Printf("%s pull %s", prefix, cd.Card.Value(vcard.FieldFormattedName))
+		if !apply {
+			return
+		}
+		if lc == nil {
+			p := uniqueBucketPath(dir, cd.Card.Value(vcard.FieldFormattedName))
+			files[p] = []vcard.Card{cd.Card}
+			local[cd.Card.Value(vcard.FieldUID)] = localCard{Path: p, Card: cd.Card}
+			logBucketErr("mirror write", p, writeVCF(p, files[p]))
+			return
+		}
+		files[lc.Path][lc.Index] = cd.Card
+		local[cd.Card.Value(vcard.FieldUID)] = localCard{Path: lc.Path, Index: lc.Index, Card: cd.Card}
+		logBucketErr("mirror write", lc.Path, writeVCF(lc.Path, files[lc.Path]))
+	}
+
+	for _, uid := range keys {
+		lc, hasLocal := local[uid]
+		rc, hasRemote := remoteCards[uid]
+		st, known := state[uid]
+		switch {
+		case hasLocal && hasRemote:
+			localChanged := !known || cardHash(lc.Card) != st.Hash
+			remoteChanged := !known || rc.Ref.ETag != st.ETag
+			switch {
+			case localChanged && remoteChanged:
+				if encodeCard(stripRev(lc.Card)) == encodeCard(stripRev(rc.Card)) {
+					continue
+				}
+				if rc.Card.Value(vcard.FieldRevision) > lc.Card.Value(vcard.FieldRevision) {
+					pull(rc, &lc)
+				} else {
+					push(lc, rc.Ref)
+				}
+			case localChanged:
+				push(lc, rc.Ref)
+			case remoteChanged:
+				pull(rc, &lc)
+			}
+		case hasLocal && known:
+			// was mirrored, now gone from the collection: removed on a device
+			delLocal++
+			log.Printf("%s delete local %s (%s)", prefix, lc.Card.Value(vcard.FieldFormattedName), lc.Path)
+			if apply {
+				if _, err := quarantineCard(quarantine, bucket, lc.Card); err != nil {
+					log.Printf("%s quarantine %s: %v", prefix, lc.Path, err)
+					continue
+				}
+				quarantined = append(quarantined, fmt.Sprintf("| %s | %s |\n", lc.Card.Value(vcard.FieldFormattedName), filepath.Base(lc.Path)))
+				cards := files[lc.Path]
+				files[lc.Path] = append(cards[:lc.Index:lc.Index], cards[lc.Index+1:]...)
+				logBucketErr("mirror write", lc.Path, writeVCF(lc.Path, files[lc.Path]))
+				reindexLocal(local, lc.Path, files[lc.Path])
+			}
+		case hasLocal:
+			push(lc, cardRef{})
+		case hasRemote && known:
+			// was mirrored, now gone from the bucket: restored or deleted locally
+			delRemote++
+			log.Printf("%s delete remote %s", prefix, rc.Card.Value(vcard.FieldFormattedName))
+			if apply {
+				if err := remote.delete(ctx, rc.Ref); err != nil {
+					log.Printf("%s delete %s: %v", prefix, rc.Ref.Href, err)
+				}
+			}
+		case hasRemote:
+			pull(rc, nil)
+		}
+	}
+	if len(quarantined) > 0 {
+		writeTrashReport("buckets mirror", quarantine, append([]string{"# Bucket mirror " + stamp + " (" + bucket + ")\n\n", "| Name | File |\n", "|---|---|\n"}, quarantined...))
+	}
+
+	if apply {
+		// record what both sides look like now
+		newState := mirrorState{}
+		refs, err := remote.list(ctx)
+		if err != nil {
+			return err
+		}
+		byHref := map[string]string{}
+		for _, ref := range refs {
+			byHref[ref.Href] = ref.ETag
+		}
+		for _, ref := range refs {
+			cd, err := remote.get(ctx, ref)
+			if err != nil {
+				continue
+			}
+			uid := cd.Card.Value(vcard.FieldUID)
+			lc, ok := local[uid]
+			if !ok {
+				continue
+			}
+			newState[uid] = mirrorEntry{Href: ref.Href, ETag: byHref[ref.Href], Hash: cardHash(lc.Card)}
+		}
+		if err := saveMirrorState(dir, newState); err != nil {
+			return err
+		}
+	}
+	log.Printf("%s pushed %d, pulled %d, deleted %d remote / %d local. apply=%v", prefix, pushed, pulled, delRemote, delLocal, apply)
+	return nil
+}
+
+// reindexLocal refreshes card positions after a file lost a card.
+func reindexLocal(local map[string]localCard, p string, cards []vcard.Card) {
+	for i, c := range cards {
+		local[c.Value(vcard.FieldUID)] = localCard{Path: p, Index: i, Card: c}
+	}
+}
+
+func stripRev(card vcard.Card) vcard.Card {
+	cp := copyCard(card)
+	delete(cp, vcard.FieldRevision)
+	return cp
+}
+
+// copyCard returns a card whose property lists can be replaced without touching card.
+func copyCard(card vcard.Card) vcard.Card {
+	cp := vcard.Card{}
+	for k, v := range card {
+		cp[k] = append([]*vcard.Field(nil), v...)
+	}
+	return cp
+}
+
+// uniqueBucketPath returns dir/<safe name>.vcf, suffixed -2, -3, ... when taken.
+func uniqueBucketPath(dir, name string) string {
+	base := safeFileName(name)
+	if base == "" {
+		base = randomID()
+	}
+	p := filepath.Join(dir, base+".vcf")
+	for i := 2; ; i++ {
+		if !storeExists(p) {
+			return p
+		}
+		p = filepath.Join(dir, fmt.Sprintf("%s-%d.vcf", base, i))
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	vcard "github.com/emersion/go-vcard"
+
+	"github.com/user/dav-manager/internal/names"
+)
+
+var (
+	nameOverridesOnce sync.Once
+	nameOverrides     map[string]names.Name
+)
+
+// loadNameOverrides reads NAME_OVERRIDES (default name-overrides.json), a map from display
+// name to "family;given;additional;prefix;suffix" for names the parser gets wrong.
+func loadNameOverrides() map[string]names.Name {
+	nameOverridesOnce.Do(func() {
+		nameOverrides = map[string]names.Name{}
+		path := getenv("NAME_OVERRIDES", "name-overrides.json")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		var m map[string]string
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Printf("[warn] %s: %v", path, err)
+			return
+		}
+		for fn, v := range m {
+			n, err := names.ParseOverride(v)
+			if err != nil {
+				log.Printf("[warn] %s: %q: %v", path, fn, err)
+				continue
+			}
+			nameOverrides[norm(fn)] = n
+		}
+	})
+	return nameOverrides
+}
+
+// structuredName returns the N components for a display name: the override file entry if
+// there is one, else the parser's split.
+func structuredName(fn string) names.Name {
+	if n, ok := loadNameOverrides()[norm(fn)]; ok {
+		return n
+	}
+	return names.Parse(fn)
+}
+
+// displayName applies names.Display with the rules file's casing exceptions.
+func displayName(fn string) (string, []string) {
+	return names.DisplayWith(fn, names.Options{
+		LowercaseWords: rules().Names.LowercaseWords,
+		KeepCase:       rules().Names.KeepCase,
+	})
+}
+
+// setStructuredName sets N from fn, or from an explicit override ("family;given;...").
+// With names.structured: false in the rules file N is FN as one component instead.
+func setStructuredName(card *vcard.Card, fn, override string) error {
+	n := structuredName(fn)
+	if !structuredNames() {
+		n = names.Name{Family: strings.TrimSpace(fn)}
+	}
+	if strings.TrimSpace(override) != "" {
+		var err error
+		if n, err = names.ParseOverride(override); err != nil {
+			return err
+		}
+	}
+	card.SetName(&vcard.Name{
+		FamilyName:      n.Family,
+		GivenName:       n.Given,
+		AdditionalName:  n.Additional,
+		HonorificPrefix: n.Prefix,
+		HonorificSuffix: n.Suffix,
+	})
+	return nil
+}
+
+// cardName returns the card's current N components.
+func cardName(card vcard.Card) names.Name {
+	n := card.Name()
+	if n == nil {
+		return names.Name{}
+	}
+	return names.Name{Family: n.FamilyName, Given: n.GivenName, Additional: n.AdditionalName,
+		Prefix: n.HonorificPrefix, Suffix: n.HonorificSuffix}
+}
+
+// nameInSync reports whether N still describes FN (same words, in any split), so
+// hand-tuned N values survive rewrites while stale ones get replaced.
+func nameInSync(card vcard.Card) bool {
+	fn := strings.TrimSpace(card.Value(vcard.FieldFormattedName))
+	cur := cardName(card)
+	if cur.Components() == 0 {
+		return false
+	}
+	if !structuredNames() {
+		return cur.Family == fn && cur.Components() == 1
+	}
+	return cur == structuredName(fn) || cur.Matches(fn)
+}
+
+// syncStructuredName re-derives N from FN when N is missing or stale, and with splitLegacy
+// also when N is the whole FN in one component. It reports whether N changed.
+func syncStructuredName(card *vcard.Card, splitLegacy bool) bool {
+	fn := strings.TrimSpace(card.Value(vcard.FieldFormattedName))
+	if fn == "" {
+		return false
+	}
+	if nameInSync(*card) && !(splitLegacy && structuredNames() && legacyName(*card)) {
+		return false
+	}
+	setStructuredName(card, fn, "")
+	return true
+}
+
+// legacyName reports whether N is the whole FN stuffed into one component, as older
+// versions of this tool wrote it.
+func legacyName(card vcard.Card) bool {
+	cur := cardName(card)
+	fn := strings.TrimSpace(card.Value(vcard.FieldFormattedName))
+	return cur.Components() == 1 && strings.Contains(fn, " ") &&
+		(cur.Family == fn || cur.Given == fn)
+}
+
+// checkNameOverrideOrDie rejects a malformed --n value.
+func checkNameOverrideOrDie(cmd, override string) {
+	if override == "" {
+		return
+	}
+	if _, err := names.ParseOverride(override); err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+}
+
+// normalizeNames rewrites every server contact's FN to the display standard (see
+// names.Display), moves stripped honorifics into NOTE and keeps N in step. Without
+// apply it prints a diff of what would change.
+func normalizeNames(apply bool) {
+	client := newClient()
+	ctx := context.Background()
+	cards := mustFetch(client)
+	updated := 0
+	for _, cd := range cards {
+		fn := cd.Card.Value(vcard.FieldFormattedName)
+		diff := normalizeDisplayName(&cd.Card)
+		if len(diff) == 0 {
+			continue
+		}
+		updated++
+		if !apply {
+			fmt.Printf("%s\n  %s\n", strings.TrimSpace(fn), strings.Join(diff, "\n  "))
+			continue
+		}
+		setRevNow(&cd.Card)
+		if err := client.put(ctx, cd.Ref, cd.Card); err != nil {
+			log.Printf("normalize-names put %s: %v", cd.Ref.Href, err)
+		}
+	}
+	log.Printf("normalize-names changed %d contact(s). apply=%v", updated, apply)
+}
+
+// normalizeDisplayName applies names.Display to the card's FN, recording stripped
+// honorifics in NOTE. It returns diff lines for what changed (none if nothing did).
+func normalizeDisplayName(card *vcard.Card) []string {
+	fn := card.Value(vcard.FieldFormattedName)
+	clean, honorifics := displayName(fn)
+	if clean == "" {
+		return nil
+	}
+	diff := []string{}
+	if clean != fn {
+		diff = append(diff, fmt.Sprintf("- FN   %s", fn), fmt.Sprintf("+ FN   %s", clean))
+		renameKeepingN(card, fn, clean)
+	}
+	if len(honorifics) > 0 {
+		line := "Honorific: " + strings.Join(honorifics, " ")
+		note := strings.TrimSpace(card.Value(vcard.FieldNote))
+		if !strings.Contains(note, line) {
+			diff = append(diff, "+ NOTE "+line)
+			if note != "" {
+				line = note + "\n" + line
+			}
+			card.SetValue(vcard.FieldNote, line)
+		}
+	}
+	return diff
+}
+
+// renameKeepingN sets FN and re-derives N when it was derived from the old FN (or is
+// missing/legacy); hand-tuned N values are only replaced once they no longer match.
+func renameKeepingN(card *vcard.Card, oldFN, newFN string) {
+	cur := cardName(*card)
+	card.SetValue(vcard.FieldFormattedName, newFN)
+	if cur.Components() == 0 || cur == structuredName(oldFN) || (cur.Components() == 1 && (cur.Family == oldFN || cur.Given == oldFN)) {
+		setStructuredName(card, newFN, "")
+		return
+	}
+	syncStructuredName(card, false)
+}
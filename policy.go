@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// defaultTrashRetention applies when the rules file sets no trash.retention.
+const defaultTrashRetention = 90 * 24 * time.Hour
+
+// parseAge reads a policy age: <n>d, <n>w, <n>m (30 days) or <n>y (365 days). "" and
+// "never" yield 0, meaning no limit.
+func parseAge(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "never" {
+		return 0, nil
+	}
+	days := map[byte]int{'d': 1, 'w': 7, 'm': 30, 'y': 365}[s[len(s)-1]]
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if days == 0 || err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not an age like 90d, 6w, 6m, 2y or never", s)
+	}
+	return time.Duration(n*days) * 24 * time.Hour, nil
+}
+
+// bucketPolicy returns when cards of bucket are due for review and for expiry (0 = never).
+// Each key falls back to the "*" entry when the bucket does not set it.
+func bucketPolicy(bucket string) (review, expire time.Duration) {
+	pick := func(get func(name string) string) time.Duration {
+		v := get(bucket)
+		if v == "" {
+			v = get("*")
+		}
+		d, _ := parseAge(v) // validated at load
+		return d
+	}
+	review = pick(func(name string) string { return rules().Buckets[name].ReviewAfter })
+	expire = pick(func(name string) string { return rules().Buckets[name].ExpireAfter })
+	return review, expire
+}
+
+// trashRetention is how long quarantined cards stay in UN_CONTACTS/.trash.
+func trashRetention() time.Duration {
+	if d, _ := parseAge(rules().Trash.Retention); d > 0 {
+		return d
+	}
+	return defaultTrashRetention
+}
+
+// dueCard is a bucket card whose review or expiry date has passed.
+type dueCard struct {
+	bucketCard
+	Since  time.Time
+	Action string // "review" or "expire"
+}
+
+// dueCards applies the bucket policies to every dated card under root (only bucket, if set).
+// Cards without X-DAV-BUCKETED-AT are counted and at most listed for review (dated by
+// REV); they never expire.
+func dueCards(root, only string, now time.Time) ([]dueCard, int) {
+	out := []dueCard{}
+	undated := 0
+	for _, bc := range loadBucketCards(root) {
+		if only != "" && !strings.EqualFold(only, bc.Bucket) {
+			continue
+		}
+		review, expire := bucketPolicy(bc.Bucket)
+		if review == 0 && expire == 0 {
+			continue
+		}
+		// only a bucketing stamp can expire a card: REV is the last edit, and cards
+		// bucketed before stamping existed would otherwise expire at once
+		since, stamped := bucketedAt(bc.Card)
+		if !stamped {
+			undated++
+			var ok bool
+			if since, ok = bucketedTime(bc.Card); !ok {
+				continue
+			}
+		}
+		age := now.Sub(since)
+		switch {
+		case stamped && expire > 0 && age >= expire:
+			out = append(out, dueCard{bucketCard: bc, Since: since, Action: "expire"})
+		case review > 0 && age >= review, !stamped && expire > 0 && age >= expire:
+			out = append(out, dueCard{bucketCard: bc, Since: since, Action: "review"})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Action != out[j].Action {
+			return out[i].Action == "expire"
+		}
+		return out[i].Since.Before(out[j].Since)
+	})
+	return out, undated
+}
+
+// printDue implements `buckets due`.
+func printDue(only string) {
+	root := bucketRoot()
+	now := time.Now()
+	due, undated := dueCards(root, only, now)
+	if len(due) == 0 {
+		fmt.Println("nothing due")
+	} else {
+		bW, nW := len("Bucket"), len("Name")
+		for _, d := range due {
+			bW, nW = max(bW, len(d.Bucket)), max(nW, len(d.Card.Value(vcard.FieldFormattedName)))
+		}
+		fmt.Printf("%-6s  %-*s  %-*s  %-10s  %5s  %s\n", "Due", bW, "Bucket", nW, "Name", "Bucketed", "Days", "File")
+		fmt.Printf("%s  %s  %s  %s  %s  %s\n", strings.Repeat("-", 6), strings.Repeat("-", bW), strings.Repeat("-", nW), strings.Repeat("-", 10), strings.Repeat("-", 5), strings.Repeat("-", len("File")))
+		for _, d := range due {
+			p := d.Path
+			if r, err := filepath.Rel(root, p); err == nil {
+				p = r
+			}
+			fmt.Printf("%-6s  %-*s  %-*s  %-10s  %5d  %s\n", d.Action, bW, d.Bucket, nW, d.Card.Value(vcard.FieldFormattedName),
+				d.Since.Format("2006-01-02"), int(now.Sub(d.Since).Hours()/24), p)
+		}
+	}
+	if undated > 0 {
+		log.Printf("buckets due: %d card(s) under a policy have no X-DAV-BUCKETED-AT; they are dated by REV for review and never expire", undated)
+	}
+}
+
+// expireBuckets implements `buckets expire`: cards past their bucket's expire_after move
+// to UN_CONTACTS/.trash/<timestamp>/<bucket>/ (with a report.md), and trash batches older
+// than trash.retention are deleted for good. apply=false only reports.
+func expireBuckets(only string, apply bool) {
+	root := bucketRoot()
+	now := time.Now()
+	due, _ := dueCards(root, only, now)
+	stamp := now.Format(trashStampLayout)
+	quarantine := filepath.Join(root, trashDir, stamp)
+	drop := map[string]map[int]bool{}
+	report := []string{"# Bucket expiry " + stamp + "\n\n", "| Bucket | Name | Bucketed | File |\n", "|---|---|---|---|\n"}
+	expired := 0
+	for _, d := range due {
+		if d.Action != "expire" {
+			continue
+		}
+		expired++
+		name := d.Card.Value(vcard.FieldFormattedName)
+		rel, _ := filepath.Rel(root, d.Path)
+		log.Printf("expire [%s] %s (bucketed %s, %s)", d.Bucket, name, d.Since.Format("2006-01-02"), rel)
+		report = append(report, fmt.Sprintf("| %s | %s | %s | %s |\n", d.Bucket, name, d.Since.Format("2006-01-02"), rel))
+		if !apply {
+			continue
+		}
+		if _, err := quarantineCard(quarantine, d.Bucket, d.Card); err != nil {
+			log.Fatalf("buckets expire: %v", err)
+		}
+		if drop[d.Path] == nil {
+			drop[d.Path] = map[int]bool{}
+		}
+		drop[d.Path][d.Index] = true
+	}
+	if apply && expired > 0 {
+		rewriteBucketFiles("buckets expire", drop, nil)
+		writeTrashReport("buckets expire", quarantine, report)
+	}
+	purged := purgeTrash(root, now, apply)
+	log.Printf("buckets expire: %d card(s) expired, %d trash batch(es) past retention removed. apply=%v", expired, purged, apply)
+}
+
+// purgeTrash deletes .trash/<timestamp> batches older than trashRetention.
+func purgeTrash(root string, now time.Time, apply bool) int {
+	entries, err := storeReadDir(filepath.Join(root, trashDir))
+	if err != nil {
+		return 0
+	}
+	keep := trashRetention()
+	purged := 0
+	for _, e := range entries {
+		if !e.IsDir {
+			continue
+		}
+		created, err := time.ParseInLocation(trashStampLayout, e.Name, time.Local)
+		if err != nil {
+			if e.ModTime.IsZero() {
+				continue
+			}
+			created = e.ModTime
+		}
+		if now.Sub(created) < keep {
+			continue
+		}
+		purged++
+		p := filepath.Join(root, trashDir, e.Name)
+		log.Printf("purge %s (older than %d days)", p, int(keep.Hours()/24))
+		if apply {
+			logBucketErr("buckets expire: purge", p, storeRemove(p))
+		}
+	}
+	return purged
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// Provenance properties stamped on cards written into a bucket.
+const (
+	fieldBucketedAt   = "X-DAV-BUCKETED-AT"
+	fieldBucketReason = "X-DAV-BUCKET-REASON"
+	fieldOriginalFN   = "X-DAV-ORIGINAL-FN"
+	fieldSourceHref   = "X-DAV-SOURCE-HREF"
+)
+
+var provenanceFields = []string{fieldBucketedAt, fieldBucketReason, fieldOriginalFN, fieldSourceHref}
+
+// stampProvenance records when, why and from where a card left the server. It must run
+// before any rename so X-DAV-ORIGINAL-FN keeps the server-side name.
+func stampProvenance(card *vcard.Card, reason, href string) {
+	card.SetValue(fieldBucketedAt, time.Now().UTC().Format(time.RFC3339))
+	if reason = strings.TrimSpace(reason); reason != "" {
+		card.SetValue(fieldBucketReason, reason)
+	} else {
+		delete(*card, fieldBucketReason)
+	}
+	card.SetValue(fieldOriginalFN, strings.TrimSpace(card.Value(vcard.FieldFormattedName)))
+	if href != "" {
+		card.SetValue(fieldSourceHref, href)
+	}
+}
+
+// clearProvenance removes the bucket stamps (used when a card goes back to the server)
+// and returns the original name and href they recorded.
+func clearProvenance(card *vcard.Card) (originalFN, sourceHref string) {
+	originalFN = strings.TrimSpace(card.Value(fieldOriginalFN))
+	sourceHref = strings.TrimSpace(card.Value(fieldSourceHref))
+	for _, f := range provenanceFields {
+		delete(*card, f)
+	}
+	return originalFN, sourceHref
+}
+
+// bucketedDate returns the date part of X-DAV-BUCKETED-AT ("" when unstamped).
+func bucketedDate(card vcard.Card) string {
+	v := card.Value(fieldBucketedAt)
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.Format("2006-01-02")
+	}
+	return v
+}
+
+// revLayouts are the REV/timestamp forms seen in the wild, most common first.
+var revLayouts = []string{"20060102T150405Z", time.RFC3339, "2006-01-02T15:04:05Z", "20060102T150405", "2006-01-02", "20060102"}
+
+// bucketedTime is when the card entered its bucket: X-DAV-BUCKETED-AT, else its REV.
+// REV is only an estimate (the last edit), good enough for stats but not for expiry;
+// see bucketedAt.
+func bucketedTime(card vcard.Card) (time.Time, bool) {
+	if t, ok := bucketedAt(card); ok {
+		return t, true
+	}
+	return parseRevTime(card.Value(vcard.FieldRevision))
+}
+
+// bucketedAt is the X-DAV-BUCKETED-AT stamp alone.
+func bucketedAt(card vcard.Card) (time.Time, bool) {
+	return parseRevTime(card.Value(fieldBucketedAt))
+}
+
+func parseRevTime(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	for _, layout := range revLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
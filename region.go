@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+
+	"github.com/user/dav-manager/internal/phone"
+)
+
+// fieldRegion is the per-contact override for DEFAULT_REGION.
+const fieldRegion = "X-DAV-REGION"
+
+func validRegion(code string) bool { return phone.IsRegion(code) }
+
+// defaultRegion returns DEFAULT_REGION, else the rules file's home_region (IN when unset
+// or unknown).
+func defaultRegion() string {
+	code := strings.ToUpper(strings.TrimSpace(getenv("DEFAULT_REGION", rules().HomeRegion)))
+	if !validRegion(code) {
+		return "IN"
+	}
+	return code
+}
+
+// cardRegion returns the card's X-DAV-REGION override, falling back to DEFAULT_REGION.
+func cardRegion(card vcard.Card) string {
+	if code := strings.ToUpper(strings.TrimSpace(card.Value(fieldRegion))); validRegion(code) {
+		return code
+	}
+	return defaultRegion()
+}
+
+// setCardRegion stores a per-contact region override; an empty code leaves the card untouched.
+func setCardRegion(card *vcard.Card, code string) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return
+	}
+	card.SetValue(fieldRegion, code)
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// reviewBuckets are the bucket shortcuts offered by the review UI.
+var reviewBuckets = []string{"psychology", "corporate", "lost-in-time", "neutral"}
+
+// reviewAction is one queued decision from the review session.
+type reviewAction struct {
+	Kind   string // edit, merge, move, delete
+	Cards  []cardData
+	Bucket string
+	Field  string
+	Value  string
+}
+
+func (a reviewAction) String() string {
+	names := []string{}
+	for _, cd := range a.Cards {
+		names = append(names, cd.Card.Value(vcard.FieldFormattedName))
+	}
+	switch a.Kind {
+	case "edit":
+		return fmt.Sprintf("edit   %s: %s = %q", names[0], a.Field, a.Value)
+	case "merge":
+		return fmt.Sprintf("merge  %s <- %s", names[0], strings.Join(names[1:], ", "))
+	case "move":
+		return fmt.Sprintf("move   %s -> %s", strings.Join(names, ", "), a.Bucket)
+	case "delete":
+		return fmt.Sprintf("delete %s", strings.Join(names, ", "))
+	}
+	return a.Kind
+}
+
+// reviewSession pages through cards or duplicate clusters and queues actions.
+type reviewSession struct {
+	in        *bufio.Reader
+	out       io.Writer
+	queue     []reviewAction
+	backupDir string
+}
+
+func runReview(duplicates bool, apply bool, backupDir string) {
+	client := newClient()
+	cards := mustFetch(client)
+	sort.Slice(cards, func(i, j int) bool {
+		return norm(cards[i].Card.Value(vcard.FieldFormattedName)) < norm(cards[j].Card.Value(vcard.FieldFormattedName))
+	})
+	pages := [][]cardData{}
+	if duplicates {
+		pages = duplicateClusters(cards)
+		if len(pages) == 0 {
+			log.Printf("review: no duplicate clusters found")
+			return
+		}
+	} else {
+		for _, cd := range cards {
+			pages = append(pages, []cardData{cd})
+		}
+	}
+	s := &reviewSession{in: bufio.NewReader(os.Stdin), out: os.Stdout, backupDir: backupDir}
+	s.loop(pages)
+	if len(s.queue) == 0 {
+		log.Printf("review: nothing queued")
+		return
+	}
+	fmt.Fprintln(s.out, "\nQueued actions:")
+	for i, a := range s.queue {
+		fmt.Fprintf(s.out, "  %2d. %s\n", i+1, a)
+	}
+	if !apply {
+		log.Printf("[dry-run] %d action(s) queued; rerun with --apply to write them", len(s.queue))
+		return
+	}
+	if s.ask(fmt.Sprintf("Apply %d action(s)? [y/N] ", len(s.queue))) != "y" {
+		log.Printf("review: aborted, nothing applied")
+		return
+	}
+	s.applyQueue(client)
+}
+
+func (s *reviewSession) loop(pages [][]cardData) {
+	for i := 0; i < len(pages); i++ {
+		page := pages[i]
+		fmt.Fprintf(s.out, "\n[%d/%d]\n", i+1, len(pages))
+		fmt.Fprint(s.out, renderSideBySide(page, reviewWidth()))
+		help := "(k)eep (e)dit (b)ucket (d)elete (s)kip (p)rev (q)uit"
+		if len(page) > 1 {
+			help = "(k)eep (e)dit (m)erge (b)ucket (d)elete (s)kip (p)rev (q)uit"
+		}
+		switch s.ask(help + " > ") {
+		case "k", "s", "":
+			// nothing to queue
+		case "p":
+			if i > 0 {
+				i -= 2
+			} else {
+				i--
+			}
+		case "q":
+			return
+		case "e":
+			if a, ok := s.editAction(page); ok {
+				s.queue = append(s.queue, a)
+			}
+		case "m":
+			if len(page) < 2 {
+				fmt.Fprintln(s.out, "merge needs a duplicate cluster (--duplicates)")
+				i--
+				continue
+			}
+			if a, ok := s.mergeAction(page); ok {
+				s.queue = append(s.queue, a)
+			}
+		case "b":
+			if a, ok := s.moveAction(page); ok {
+				s.queue = append(s.queue, a)
+			}
+		case "d":
+			if cards, ok := s.pick(page, "delete", false); ok {
+				s.queue = append(s.queue, reviewAction{Kind: "delete", Cards: cards})
+			}
+		default:
+			fmt.Fprintln(s.out, "unknown key")
+			i--
+		}
+	}
+}
+
+// ask prints a prompt and returns the first rune of the answer, lowercased.
+func (s *reviewSession) ask(prompt string) string {
+	line := s.askLine(prompt)
+	if line == "" {
+		return ""
+	}
+	return strings.ToLower(string([]rune(line)[0]))
+}
+
+func (s *reviewSession) askLine(prompt string) string {
+	fmt.Fprint(s.out, prompt)
+	line, err := s.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "q"
+	}
+	return strings.TrimSpace(line)
+}
+
+// pick selects cards of a cluster; single-card pages need no choice and allowAll accepts "a".
+func (s *reviewSession) pick(page []cardData, verb string, allowAll bool) ([]cardData, bool) {
+	if len(page) == 1 {
+		return page, true
+	}
+	prompt := fmt.Sprintf("%s which card? [1-%d] ", verb, len(page))
+	if allowAll {
+		prompt = fmt.Sprintf("%s which card? [1-%d, a=all] ", verb, len(page))
+	}
+	ans := s.askLine(prompt)
+	if allowAll && strings.EqualFold(ans, "a") {
+		return page, true
+	}
+	idx, err := strconv.Atoi(ans)
+	if err != nil || idx < 1 || idx > len(page) {
+		fmt.Fprintln(s.out, "invalid choice")
+		return nil, false
+	}
+	return page[idx-1 : idx], true
+}
+
+func (s *reviewSession) editAction(page []cardData) (reviewAction, bool) {
+	picked, ok := s.pick(page, "edit", false)
+	if !ok {
+		return reviewAction{}, false
+	}
+	cd := picked[0]
+	field := ""
+	switch s.ask("field: (n)ame (e)mails (p)hones n(o)te > ") {
+	case "n":
+		field = "name"
+	case "e":
+		field = "emails"
+	case "p":
+		field = "phones"
+	case "o":
+		field = "note"
+	default:
+		return reviewAction{}, false
+	}
+	value := s.askLine(field + " = ")
+	if value == "" && field != "note" {
+		return reviewAction{}, false
+	}
+	return reviewAction{Kind: "edit", Cards: []cardData{cd}, Field: field, Value: value}, true
+}
+
+func (s *reviewSession) mergeAction(page []cardData) (reviewAction, bool) {
+	ans := s.askLine(fmt.Sprintf("keep which card as primary? [1-%d] ", len(page)))
+	idx, err := strconv.Atoi(ans)
+	if err != nil || idx < 1 || idx > len(page) {
+		fmt.Fprintln(s.out, "invalid choice")
+		return reviewAction{}, false
+	}
+	cards := []cardData{page[idx-1]}
+	for i, cd := range page {
+		if i != idx-1 {
+			cards = append(cards, cd)
+		}
+	}
+	return reviewAction{Kind: "merge", Cards: cards}, true
+}
+
+func (s *reviewSession) moveAction(page []cardData) (reviewAction, bool) {
+	opts := []string{}
+	for i, b := range reviewBuckets {
+		opts = append(opts, fmt.Sprintf("%d=%s", i+1, b))
+	}
+	ans := s.askLine("bucket " + strings.Join(opts, " ") + " (or name) > ")
+	bucket := ans
+	if idx, err := strconv.Atoi(ans); err == nil {
+		if idx < 1 || idx > len(reviewBuckets) {
+			return reviewAction{}, false
+		}
+		bucket = reviewBuckets[idx-1]
+	}
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) {
+		return reviewAction{}, false
+	}
+	cards, ok := s.pick(page, "move", true)
+	if !ok {
+		return reviewAction{}, false
+	}
+	return reviewAction{Kind: "move", Cards: cards, Bucket: bucket}, true
+}
+
+// applyQueue replays the queued actions through the regular update/merge/move/delete paths.
+func (s *reviewSession) applyQueue(client *radClient) {
+	ctx := context.Background()
+	done := map[string]bool{} // hrefs already removed from the server
+	// cards as last written by this queue: a later action on the same card must build on
+	// the edit (and not PUT the queue-time snapshot with its stale ETag)
+	latest := map[string]cardData{}
+	saved := func(cd cardData) {
+		cd.Ref.ETag = "" // the server assigned a new one we don't know
+		latest[cd.Ref.Href] = cd
+	}
+	for _, a := range s.queue {
+		live := []cardData{}
+		for _, cd := range a.Cards {
+			if done[cd.Ref.Href] {
+				continue
+			}
+			if l, ok := latest[cd.Ref.Href]; ok {
+				cd = l
+			}
+			live = append(live, cardData{Ref: cd.Ref, Card: copyCard(cd.Card)})
+		}
+		if len(live) == 0 || (a.Kind == "merge" && live[0].Ref.Href != a.Cards[0].Ref.Href) {
+			log.Printf("review: skip %s (card already moved/deleted)", a)
+			continue
+		}
+		switch a.Kind {
+		case "edit":
+			cd := live[0]
+			var err error
+			switch a.Field {
+			case "name":
+				err = applyUpdate(&cd.Card, a.Value, nil, nil, nil)
+			case "emails":
+				err = applyUpdate(&cd.Card, "", splitCSV(a.Value), nil, nil)
+			case "phones":
+				err = applyUpdate(&cd.Card, "", nil, splitCSV(a.Value), nil)
+			case "note":
+				err = applyUpdate(&cd.Card, "", nil, nil, &a.Value)
+			}
+			if err != nil {
+				log.Printf("review edit %s: %v", cd.Ref.Href, err)
+				continue
+			}
+			if err := client.put(ctx, cd.Ref, cd.Card); err != nil {
+				log.Printf("review edit %s: %v", cd.Ref.Href, err)
+				continue
+			}
+			saved(cd)
+		case "merge":
+			primary := live[0]
+			for _, extra := range live[1:] {
+				mergeCards(&primary.Card, extra.Card)
+			}
+			if err := client.put(ctx, primary.Ref, primary.Card); err != nil {
+				log.Printf("review merge %s: %v", primary.Ref.Href, err)
+				continue
+			}
+			saved(primary)
+			for _, extra := range live[1:] {
+				if err := client.delete(ctx, extra.Ref); err != nil {
+					log.Printf("review merge delete %s: %v", extra.Ref.Href, err)
+					continue
+				}
+				done[extra.Ref.Href] = true
+			}
+		case "move":
+			for i := range live {
+				fname, err := moveCard(ctx, client, &live[i], a.Bucket, "", "review")
+				if err != nil {
+					log.Printf("review move %s: %v", live[i].Ref.Href, err)
+					continue
+				}
+				done[live[i].Ref.Href] = true
+				log.Printf("moved %s to %s", live[i].Card.Value(vcard.FieldFormattedName), fname)
+			}
+			continue
+		case "delete":
+			cd := live[0]
+			_ = os.MkdirAll(s.backupDir, 0o755)
+			fname := uniqueBucketPath(s.backupDir, cd.Card.Value(vcard.FieldFormattedName))
+			if err := backupAndDelete(ctx, client, &cd, fname); err != nil {
+				log.Printf("review delete %s: %v", cd.Ref.Href, err)
+				continue
+			}
+			done[cd.Ref.Href] = true
+			log.Printf("deleted %s (backup at %s)", cd.Card.Value(vcard.FieldFormattedName), fname)
+			continue
+		}
+		log.Printf("review: %s", a)
+	}
+}
+
+// duplicateClusters groups cards sharing a normalized name, phone or email.
+// Only clusters with more than one card are returned.
+func duplicateClusters(cards []cardData) [][]cardData {
+	plain := make([]vcard.Card, len(cards))
+	for i, cd := range cards {
+		plain[i] = cd.Card
+	}
+	res := [][]cardData{}
+	for _, group := range duplicateGroups(plain) {
+		cluster := make([]cardData, len(group))
+		for k, i := range group {
+			cluster[k] = cards[i]
+		}
+		res = append(res, cluster)
+	}
+	return res
+}
+
+// duplicateGroups returns the indexes of cards sharing a name, email or phone
+// (transitively), in input order; singletons are omitted.
+func duplicateGroups(cards []vcard.Card) [][]int {
+	parent := make([]int, len(cards))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	owner := map[string]int{}
+	for i, card := range cards {
+		keys := []string{}
+		if fn := norm(card.Value(vcard.FieldFormattedName)); fn != "" {
+			keys = append(keys, "fn:"+fn)
+		}
+		for _, k := range append(keys, contactKeys(card)...) {
+			if j, ok := owner[k]; ok {
+				parent[find(i)] = find(j)
+				continue
+			}
+			owner[k] = i
+		}
+	}
+	groups := map[int][]int{}
+	order := []int{}
+	for i := range cards {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			order = append(order, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+	res := [][]int{}
+	for _, root := range order {
+		if len(groups[root]) > 1 {
+			res = append(res, groups[root])
+		}
+	}
+	return res
+}
+
+// contactKeys returns the card's normalized email and phone keys ("email:…", "tel:…").
+func contactKeys(card vcard.Card) []string {
+	keys := []string{}
+	for _, em := range getValues(card, vcard.FieldEmail) {
+		keys = append(keys, "email:"+emailKey(em))
+	}
+	for _, ph := range getValues(card, vcard.FieldTelephone) {
+		if n := normalizePhoneIn(ph, cardRegion(card)); n != "" {
+			keys = append(keys, "tel:"+n)
+		}
+	}
+	return keys
+}
+
+// reviewFieldOrder controls the row order of the side-by-side view.
+var reviewFieldOrder = []string{
+	vcard.FieldFormattedName, vcard.FieldName, vcard.FieldTelephone, vcard.FieldEmail,
+	vcard.FieldOrganization, vcard.FieldTitle, vcard.FieldAddress, vcard.FieldBirthday,
+	vcard.FieldNote, vcard.FieldCategories, vcard.FieldURL, vcard.FieldPhoto,
+	vcard.FieldUID, vcard.FieldRevision,
+}
+
+// renderSideBySide prints every property of the cards in aligned columns, one column per card.
+func renderSideBySide(cards []cardData, width int) string {
+	present := map[string]bool{}
+	for _, cd := range cards {
+		for k := range cd.Card {
+			if k != vcard.FieldVersion {
+				present[k] = true
+			}
+		}
+	}
+	fields := []string{}
+	for _, k := range reviewFieldOrder {
+		if present[k] {
+			fields = append(fields, k)
+			delete(present, k)
+		}
+	}
+	rest := []string{}
+	for k := range present {
+		rest = append(rest, k)
+	}
+	sort.Strings(rest)
+	fields = append(fields, rest...)
+
+	labelW := 0
+	for _, f := range fields {
+		if len(f) > labelW {
+			labelW = len(f)
+		}
+	}
+	colW := (width - labelW - 2) / len(cards)
+	if colW < 16 {
+		colW = 16
+	}
+	var b strings.Builder
+	if len(cards) > 1 {
+		b.WriteString(strings.Repeat(" ", labelW+2))
+		for i := range cards {
+			b.WriteString(padCell(fmt.Sprintf("#%d", i+1), colW))
+		}
+		b.WriteString("\n")
+	}
+	for _, f := range fields {
+		rows := 0
+		for _, cd := range cards {
+			if n := len(cd.Card[f]); n > rows {
+				rows = n
+			}
+		}
+		for r := 0; r < rows; r++ {
+			label := ""
+			if r == 0 {
+				label = f
+			}
+			b.WriteString(fmt.Sprintf("%-*s  ", labelW, label))
+			for _, cd := range cards {
+				cell := ""
+				if r < len(cd.Card[f]) {
+					cell = describeField(f, cd.Card[f][r])
+				}
+				b.WriteString(padCell(cell, colW))
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func describeField(name string, f *vcard.Field) string {
+	v := f.Value
+	if name == vcard.FieldPhoto || name == vcard.FieldLogo {
+		v = fmt.Sprintf("<%d bytes>", len(f.Value))
+	}
+	v = strings.ReplaceAll(v, "\n", " / ")
+	if types := f.Params.Types(); len(types) > 0 {
+		v += " [" + strings.Join(types, ",") + "]"
+	}
+	return v
+}
+
+func padCell(s string, w int) string {
+	r := []rune(s)
+	if len(r) > w-1 {
+		r = append(r[:w-2], '…')
+	}
+	return fmt.Sprintf("%-*s", w, string(r))
+}
+
+func reviewWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 40 {
+		return n
+	}
+	return 120
+}
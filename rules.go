@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	vcard "github.com/emersion/go-vcard"
+	"gopkg.in/yaml.v3"
+)
+
+// rulesConfig is the declarative policy file (RULES_FILE, default dav-rules.yaml). Every
+// key is optional; anything left out keeps the built-in behaviour.
+type rulesConfig struct {
+	HomeRegion string `yaml:"home_region"`
+	Phones     struct {
+		Labels             []string `yaml:"labels"`
+		DefaultLabel       string   `yaml:"default_label"`
+		InternationalFirst *bool    `yaml:"international_first"`
+	} `yaml:"phones"`
+	Emails struct {
+		Labels      []string `yaml:"labels"`
+		AliasDedupe *bool    `yaml:"alias_dedupe"`
+	} `yaml:"emails"`
+	Names struct {
+		Structured     *bool    `yaml:"structured"`
+		LowercaseWords []string `yaml:"lowercase_words"`
+		KeepCase       []string `yaml:"keep_case"`
+	} `yaml:"names"`
+	Required []string `yaml:"required"`
+	Buckets  map[string]struct {
+		Required    []string `yaml:"required"`
+		ReviewAfter string   `yaml:"review_after"`
+		ExpireAfter string   `yaml:"expire_after"`
+	} `yaml:"buckets"`
+	Trash struct {
+		Retention string `yaml:"retention"`
+	} `yaml:"trash"`
+	Lint struct {
+		Disable  []string          `yaml:"disable"`
+		Severity map[string]string `yaml:"severity"`
+	} `yaml:"lint"`
+	Triage struct {
+		CompanyWords []string `yaml:"company_words"`
+	} `yaml:"triage"`
+	VCard struct {
+		Version     string            `yaml:"version"`
+		Collections map[string]string `yaml:"collections"`
+	} `yaml:"vcard"`
+}
+
+var (
+	rulesOnce sync.Once
+	rulesCfg  *rulesConfig
+)
+
+// rules returns the loaded rules file; a missing file yields the defaults and a malformed
+// one is fatal, since silently ignoring a policy would be worse than stopping.
+func rules() *rulesConfig {
+	rulesOnce.Do(func() {
+		path := getenv("RULES_FILE", "dav-rules.yaml")
+		cfg, err := loadRules(path)
+		if err != nil {
+			log.Fatalf("rules %s: %v", path, err)
+		}
+		rulesCfg = cfg
+	})
+	return rulesCfg
+}
+
+func loadRules(path string) (*rulesConfig, error) {
+	cfg := &rulesConfig{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return cfg, cfg.validate()
+}
+
+func (c *rulesConfig) validate() error {
+	if c.HomeRegion != "" && !validRegion(c.HomeRegion) {
+		return fmt.Errorf("home_region %q is not a known region code", c.HomeRegion)
+	}
+	for _, l := range c.Phones.Labels {
+		if !containsString(phoneLabels, strings.ToLower(l)) {
+			return fmt.Errorf("phones.labels: %q is not one of %s", l, strings.Join(phoneLabels, "|"))
+		}
+	}
+	if l := strings.ToLower(c.Phones.DefaultLabel); l != "" && !containsString(allowedPhoneLabelsIn(c), l) {
+		return fmt.Errorf("phones.default_label %q is not an allowed label", c.Phones.DefaultLabel)
+	}
+	for _, l := range c.Emails.Labels {
+		if !containsString(emailLabels, strings.ToLower(l)) {
+			return fmt.Errorf("emails.labels: %q is not one of %s", l, strings.Join(emailLabels, "|"))
+		}
+	}
+	fields := append([]string{}, c.Required...)
+	for _, b := range c.Buckets {
+		fields = append(fields, b.Required...)
+	}
+	for _, f := range fields {
+		if requiredProp(f) == "" {
+			return fmt.Errorf("required: unknown field %q", f)
+		}
+	}
+	for name, b := range c.Buckets {
+		for key, v := range map[string]string{"review_after": b.ReviewAfter, "expire_after": b.ExpireAfter} {
+			if _, err := parseAge(v); err != nil {
+				return fmt.Errorf("buckets.%s.%s: %v", name, key, err)
+			}
+		}
+	}
+	if _, err := parseAge(c.Trash.Retention); err != nil {
+		return fmt.Errorf("trash.retention: %v", err)
+	}
+	versions := map[string]string{"vcard.version": c.VCard.Version}
+	for col, v := range c.VCard.Collections {
+		versions["vcard.collections."+col] = v
+	}
+	for key, v := range versions {
+		if v != "" && v != "3.0" && v != "4.0" {
+			return fmt.Errorf("%s: %q is not 3.0 or 4.0", key, v)
+		}
+	}
+	for id, sev := range c.Lint.Severity {
+		if severityRank(sev) < 0 {
+			return fmt.Errorf("lint.severity.%s: %q is not one of %s", id, sev, strings.Join(lintSeverities, "|"))
+		}
+	}
+	return nil
+}
+
+func allowedPhoneLabelsIn(c *rulesConfig) []string {
+	if len(c.Phones.Labels) == 0 {
+		return phoneLabels
+	}
+	out := []string{}
+	for _, l := range c.Phones.Labels {
+		out = append(out, strings.ToLower(l))
+	}
+	return out
+}
+
+// allowedPhoneLabels are the TEL types accepted in specs and by lint.
+func allowedPhoneLabels() []string { return allowedPhoneLabelsIn(rules()) }
+
+// defaultPhoneLabel is given to untyped mobile (or unclassified) numbers.
+func defaultPhoneLabel() string {
+	if l := strings.ToLower(rules().Phones.DefaultLabel); l != "" {
+		return l
+	}
+	return "cell"
+}
+
+// internationalFirst reports whether numbers outside the home region are listed first.
+func internationalFirst() bool {
+	if p := rules().Phones.InternationalFirst; p != nil {
+		return *p
+	}
+	return true
+}
+
+// allowedEmailLabels are the EMAIL types accepted in specs and by lint.
+func allowedEmailLabels() []string {
+	if len(rules().Emails.Labels) == 0 {
+		return emailLabels
+	}
+	out := []string{}
+	for _, l := range rules().Emails.Labels {
+		out = append(out, strings.ToLower(l))
+	}
+	return out
+}
+
+// aliasDedupe reports whether provider aliases fold together; EMAIL_ALIAS_DEDUPE wins
+// over the rules file.
+func aliasDedupe() bool {
+	if v := os.Getenv("EMAIL_ALIAS_DEDUPE"); v != "" {
+		return v != "0"
+	}
+	if p := rules().Emails.AliasDedupe; p != nil {
+		return *p
+	}
+	return false
+}
+
+// structuredNames reports whether N is split into components (true) or holds FN as a
+// single component, the old behaviour some clients still expect.
+func structuredNames() bool {
+	if p := rules().Names.Structured; p != nil {
+		return *p
+	}
+	return true
+}
+
+// requiredProp maps a rules-file field name to its vCard property ("" if unknown).
+func requiredProp(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "tel", "phone":
+		return vcard.FieldTelephone
+	case "email":
+		return vcard.FieldEmail
+	case "adr", "address":
+		return vcard.FieldAddress
+	case "fn", "name":
+		return vcard.FieldFormattedName
+	case "n":
+		return vcard.FieldName
+	case "note":
+		return vcard.FieldNote
+	case "org":
+		return vcard.FieldOrganization
+	case "photo":
+		return vcard.FieldPhoto
+	case "bday":
+		return vcard.FieldBirthday
+	}
+	return ""
+}
+
+// requiredFields returns the fields a card must carry: the top-level list for server
+// cards, or the bucket's own list (falling back to the "*" entry, then to tel as
+// clean-buckets always warned) for bucket cards.
+func requiredFields(bucket string) []string {
+	if bucket == "" {
+		return rules().Required
+	}
+	if b, ok := rules().Buckets[bucket]; ok && b.Required != nil {
+		return b.Required
+	}
+	if b, ok := rules().Buckets["*"]; ok && b.Required != nil {
+		return b.Required
+	}
+	if bucket == "email_only" {
+		return []string{"email"}
+	}
+	return []string{"tel"}
+}
+
+// missingRequired lists the required fields the card lacks.
+func missingRequired(card vcard.Card, bucket string) []string {
+	out := []string{}
+	for _, f := range requiredFields(bucket) {
+		if strings.TrimSpace(card.Value(requiredProp(f))) == "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// checkLintConfig rejects rule IDs in the lint section that no rule has (checked when lint
+// runs rather than at load, as the rules table itself consults the config).
+func checkLintConfig() error {
+	ids := append([]string{}, rules().Lint.Disable...)
+	for id := range rules().Lint.Severity {
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		found := false
+		for _, r := range lintRules {
+			found = found || r.ID == id
+		}
+		if !found {
+			return fmt.Errorf("lint: unknown rule %q", id)
+		}
+	}
+	return nil
+}
+
+// lintEnabled reports whether a lint rule is active, and lintSeverity its configured level.
+func lintEnabled(id string) bool { return !containsString(rules().Lint.Disable, id) }
+
+func lintSeverity(r lintRule) string {
+	if s, ok := rules().Lint.Severity[r.ID]; ok {
+		return s
+	}
+	return r.Severity
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	vcard "github.com/emersion/go-vcard"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	unorm "golang.org/x/text/unicode/norm"
+)
+
+// bucketQuery holds the `buckets search` filters; empty ones match everything.
+type bucketQuery struct {
+	Phone  string
+	Email  string
+	Name   string
+	Bucket string
+}
+
+func (q bucketQuery) empty() bool {
+	return q.Phone == "" && q.Email == "" && q.Name == "" && q.Bucket == ""
+}
+
+// match reports whether the card satisfies every filter of q.
+func (q bucketQuery) match(bc bucketCard) bool {
+	if q.Bucket != "" && !strings.EqualFold(q.Bucket, bc.Bucket) {
+		return false
+	}
+	if q.Phone != "" && !anyMatch(getValues(bc.Card, vcard.FieldTelephone), func(v string) bool { return phoneMatches(q.Phone, v, cardRegion(bc.Card)) }) {
+		return false
+	}
+	if q.Email != "" && !anyMatch(getValues(bc.Card, vcard.FieldEmail), func(v string) bool { return emailMatches(q.Email, v) }) {
+		return false
+	}
+	if q.Name != "" && !anyMatch([]string{bc.Card.Value(vcard.FieldFormattedName), bc.Card.Value(fieldOriginalFN)}, func(v string) bool { return nameMatches(q.Name, v) }) {
+		return false
+	}
+	return true
+}
+
+func anyMatch(values []string, ok func(string) bool) bool {
+	for _, v := range values {
+		if ok(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// phoneMatches compares digits only, so formatting, a missing country code or a trunk
+// zero don't matter; four or more digits may match part of the number.
+func phoneMatches(query, value, region string) bool {
+	q := strings.TrimLeft(digitsOnly(query), "0")
+	if len(q) < 4 {
+		return false
+	}
+	v := digitsOnly(value)
+	if n := normalizePhoneIn(value, region); n != "" {
+		v = digitsOnly(n)
+	}
+	if strings.Contains(v, q) {
+		return true
+	}
+	// the query carries a country code the stored number lacks
+	national := strings.TrimLeft(digitsOnly(value), "0")
+	return len(national) >= 6 && strings.HasSuffix(q, national)
+}
+
+func digitsOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// emailMatches accepts substrings ("acme.com", "jane"), provider aliases (emailKey) and a
+// typo in a full address.
+func emailMatches(query, value string) bool {
+	q, v := norm(query), norm(value)
+	if q == "" {
+		return false
+	}
+	if strings.Contains(v, q) {
+		return true
+	}
+	return strings.Contains(q, "@") && (emailKey(q) == emailKey(v) || editDistance(q, v) <= 1)
+}
+
+// nameMatches is forgiving: every query word must prefix a name word or be within a
+// small edit distance of one (1 typo from 4 letters, 2 from 8), ignoring case and accents.
+func nameMatches(query, name string) bool {
+	qs, ns := nameWords(query), nameWords(name)
+	if len(qs) == 0 || len(ns) == 0 {
+		return false
+	}
+	for _, q := range qs {
+		found := false
+		for _, n := range ns {
+			if strings.HasPrefix(n, q) || editDistance(q, n) <= typoBudget(q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func nameWords(s string) []string {
+	return strings.FieldsFunc(foldAccents(strings.ToLower(s)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// foldAccents strips combining marks ("José" -> "Jose").
+func foldAccents(s string) string {
+	out, _, err := transform.String(transform.Chain(unorm.NFD, runes.Remove(runes.In(unicode.Mn)), unorm.NFC), s)
+	if err != nil {
+		return s
+	}
+	return out
+}
+
+func typoBudget(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// searchBuckets prints every bucket card matching q.
+func searchBuckets(q bucketQuery) {
+	root := bucketRoot()
+	type row struct{ Bucket, Name, Phones, Emails, Path string }
+	rows := []row{}
+	for _, bc := range loadBucketCards(root) {
+		if !q.match(bc) {
+			continue
+		}
+		p := bc.Path
+		if r, err := filepath.Rel(root, p); err == nil {
+			p = r
+		}
+		rows = append(rows, row{bc.Bucket, bc.Card.Value(vcard.FieldFormattedName),
+			strings.Join(getValues(bc.Card, vcard.FieldTelephone), ", "),
+			strings.Join(getValues(bc.Card, vcard.FieldEmail), ", "), p})
+	}
+	if len(rows) == 0 {
+		fmt.Println("no matches")
+		return
+	}
+	bW, nW, pW, eW := len("Bucket"), len("Name"), len("Phones"), len("Emails")
+	for _, r := range rows {
+		bW, nW, pW, eW = max(bW, len(r.Bucket)), max(nW, len(r.Name)), max(pW, len(r.Phones)), max(eW, len(r.Emails))
+	}
+	fmt.Printf("%-*s  %-*s  %-*s  %-*s  %s\n", bW, "Bucket", nW, "Name", pW, "Phones", eW, "Emails", "File")
+	fmt.Printf("%s  %s  %s  %s  %s\n", strings.Repeat("-", bW), strings.Repeat("-", nW), strings.Repeat("-", pW), strings.Repeat("-", eW), strings.Repeat("-", len("File")))
+	for _, r := range rows {
+		fmt.Printf("%-*s  %-*s  %-*s  %-*s  %s\n", bW, r.Bucket, nW, r.Name, pW, r.Phones, eW, r.Emails, r.Path)
+	}
+}
+
+// ageBands are the `buckets stats` age columns (upper bound in days; 0 = open-ended).
+var ageBands = []struct {
+	Label string
+	Days  int
+}{{"<30d", 30}, {"<6m", 182}, {"<1y", 365}, {"<2y", 730}, {"2y+", 0}}
+
+// bucketStats prints per-bucket counts, age distribution (X-DAV-BUCKETED-AT, else REV)
+// and how many entries lack a phone, an email or both.
+func bucketStats() {
+	type stats struct {
+		Cards, NoPhone, NoEmail, Neither, Unknown int
+		Ages                                      []int
+	}
+	now := time.Now()
+	order := []string{}
+	by := map[string]*stats{}
+	total := &stats{Ages: make([]int, len(ageBands))}
+	for _, bc := range loadBucketCards(bucketRoot()) {
+		s, ok := by[bc.Bucket]
+		if !ok {
+			s = &stats{Ages: make([]int, len(ageBands))}
+			by[bc.Bucket] = s
+			order = append(order, bc.Bucket)
+		}
+		tel := len(bc.Card[vcard.FieldTelephone]) > 0
+		mail := len(bc.Card[vcard.FieldEmail]) > 0
+		for _, st := range []*stats{s, total} {
+			st.Cards++
+			if !tel {
+				st.NoPhone++
+			}
+			if !mail {
+				st.NoEmail++
+			}
+			if !tel && !mail {
+				st.Neither++
+			}
+		}
+		t, ok := bucketedTime(bc.Card)
+		if !ok {
+			s.Unknown++
+			total.Unknown++
+			continue
+		}
+		days := int(now.Sub(t).Hours() / 24)
+		for i, band := range ageBands {
+			if band.Days == 0 || days < band.Days {
+				s.Ages[i]++
+				total.Ages[i]++
+				break
+			}
+		}
+	}
+	bW := len("Bucket")
+	for _, b := range order {
+		bW = max(bW, len(b))
+	}
+	header := fmt.Sprintf("%-*s  %5s", bW, "Bucket", "Cards")
+	for _, band := range ageBands {
+		header += fmt.Sprintf("  %5s", band.Label)
+	}
+	header += fmt.Sprintf("  %7s  %8s  %8s  %7s", "No date", "No phone", "No email", "Neither")
+	fmt.Println(header)
+	fmt.Println(strings.Repeat("-", len(header)))
+	line := func(name string, s *stats) {
+		out := fmt.Sprintf("%-*s  %5d", bW, name, s.Cards)
+		for _, n := range s.Ages {
+			out += fmt.Sprintf("  %5d", n)
+		}
+		out += fmt.Sprintf("  %7d  %8d  %8d  %7d", s.Unknown, s.NoPhone, s.NoEmail, s.Neither)
+		fmt.Println(out)
+	}
+	for _, b := range order {
+		line(b, by[b])
+	}
+	if len(order) > 1 {
+		line("total", total)
+	}
+}
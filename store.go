@@ -0,0 +1,618 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BucketStore is where the bucket tree lives. Paths are slash-separated and relative to
+// the store root ("neutral/jane-doe.vcf"); missing entries yield errors matching
+// fs.ErrNotExist, and writes create parent directories as needed.
+type BucketStore interface {
+	// Root is the prefix of the bucket paths commands print and pass around.
+	Root() string
+	ReadFile(rel string) ([]byte, error)
+	WriteFile(rel string, data []byte) error
+	// Remove deletes a file or a whole directory.
+	Remove(rel string) error
+	Rename(from, to string) error
+	ReadDir(rel string) ([]storeEntry, error)
+	Stat(rel string) (storeEntry, error)
+}
+
+type storeEntry struct {
+	Name    string
+	IsDir   bool
+	ModTime time.Time
+	Size    int64
+}
+
+var (
+	storeOnce sync.Once
+	storeImpl BucketStore
+)
+
+// bucketStore picks the store from BUCKET_STORE: an http(s) URL is a WebDAV directory, a
+// path ending in .zip a single-file archive; anything else (default UN_CONTACTS) is a
+// local directory.
+func bucketStore() BucketStore {
+	storeOnce.Do(func() {
+		loc := getenv("BUCKET_STORE", getenv("UN_CONTACTS", "/home/pi/data/smbfs/dada/un-contacts"))
+		switch {
+		case strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://"):
+			s, err := newDAVStore(loc)
+			if err != nil {
+				log.Fatalf("BUCKET_STORE: %v", err)
+			}
+			storeImpl = s
+		case strings.EqualFold(filepath.Ext(loc), ".zip"):
+			storeImpl = &zipStore{path: filepath.Clean(loc)}
+		default:
+			storeImpl = fsStore{root: filepath.Clean(loc)}
+		}
+	})
+	return storeImpl
+}
+
+// closeBuckets saves the bucket index and writes out what the store buffered during the
+// command (the zip archive). Commands defer it, and call it before exiting with a status.
+func closeBuckets() {
+	saveBucketIndex()
+	if err := flushBucketStore(); err != nil {
+		log.Fatalf("bucket store %s: %v", storeImpl.Root(), err)
+	}
+}
+
+// flushBucketStore makes buffered bucket writes durable. Anything that deletes a card from
+// the server after copying it into a bucket must call it first: a deferred flush does not
+// run on log.Fatalf.
+func flushBucketStore() error {
+	if storeImpl == nil {
+		return nil // buckets not touched
+	}
+	if f, ok := storeImpl.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// storeFor maps a bucket path (under bucketRoot) to its store and relative path; other
+// paths are plain local files.
+func storeFor(p string) (BucketStore, string) {
+	s := bucketStore()
+	root := s.Root()
+	if p == root {
+		return s, ""
+	}
+	if rel, ok := strings.CutPrefix(p, strings.TrimRight(root, "/")+"/"); ok {
+		return s, filepath.ToSlash(rel)
+	}
+	return fsStore{}, p
+}
+
+func storeRead(p string) ([]byte, error) {
+	s, rel := storeFor(p)
+	return s.ReadFile(rel)
+}
+
+func storeWrite(p string, data []byte) error {
+	s, rel := storeFor(p)
+	return s.WriteFile(rel, data)
+}
+
+func storeRemove(p string) error {
+	s, rel := storeFor(p)
+	return s.Remove(rel)
+}
+
+func storeRename(from, to string) error {
+	s, relFrom := storeFor(from)
+	_, relTo := storeFor(to)
+	return s.Rename(relFrom, relTo)
+}
+
+func storeReadDir(p string) ([]storeEntry, error) {
+	s, rel := storeFor(p)
+	return s.ReadDir(rel)
+}
+
+func storeExists(p string) bool {
+	s, rel := storeFor(p)
+	_, err := s.Stat(rel)
+	return err == nil
+}
+
+// walkStore calls fn for every file below dir, in name order; hidden directories (.trash)
+// are skipped unless hidden is set.
+func walkStore(dir string, hidden bool, fn func(p string, e storeEntry)) {
+	entries, err := storeReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		p := filepath.Join(dir, e.Name)
+		if e.IsDir {
+			if hidden || !strings.HasPrefix(e.Name, ".") {
+				walkStore(p, hidden, fn)
+			}
+			continue
+		}
+		fn(p, e)
+	}
+}
+
+// fsStore is a local (or mounted) directory.
+type fsStore struct{ root string }
+
+func (s fsStore) Root() string { return s.root }
+
+func (s fsStore) abs(rel string) string {
+	if s.root == "" {
+		return rel
+	}
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+func (s fsStore) ReadFile(rel string) ([]byte, error) { return os.ReadFile(s.abs(rel)) }
+
+func (s fsStore) WriteFile(rel string, data []byte) error {
+	p := s.abs(rel)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+func (s fsStore) Remove(rel string) error {
+	p := s.abs(rel)
+	if _, err := os.Stat(p); err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+func (s fsStore) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(s.abs(to)), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(s.abs(from), s.abs(to))
+}
+
+func (s fsStore) ReadDir(rel string) ([]storeEntry, error) {
+	entries, err := os.ReadDir(s.abs(rel))
+	if err != nil {
+		return nil, err
+	}
+	out := []storeEntry{}
+	for _, e := range entries {
+		se := storeEntry{Name: e.Name(), IsDir: e.IsDir()}
+		if info, err := e.Info(); err == nil {
+			se.ModTime, se.Size = info.ModTime(), info.Size()
+		}
+		out = append(out, se)
+	}
+	return out, nil
+}
+
+func (s fsStore) Stat(rel string) (storeEntry, error) {
+	info, err := os.Stat(s.abs(rel))
+	if err != nil {
+		return storeEntry{}, err
+	}
+	return storeEntry{Name: info.Name(), IsDir: info.IsDir(), ModTime: info.ModTime(), Size: info.Size()}, nil
+}
+
+// davStore is a plain WebDAV directory (Nextcloud, Apache mod_dav, ...). Credentials are
+// BUCKET_STORE_USER/BUCKET_STORE_PASS, defaulting to the Radicale ones.
+type davStore struct {
+	base       *url.URL // always ends in "/"
+	user, pass string
+}
+
+func newDAVStore(raw string) (*davStore, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/"
+	return &davStore{
+		base: u,
+		user: getenv("BUCKET_STORE_USER", os.Getenv("RADICALE_USER")),
+		pass: getenv("BUCKET_STORE_PASS", os.Getenv("RADICALE_PASS")),
+	}, nil
+}
+
+func (s *davStore) Root() string { return strings.TrimRight(s.base.Path, "/") }
+
+func (s *davStore) url(rel string) string {
+	u := *s.base
+	u.Path = path.Join(s.base.Path, rel)
+	if rel == "" || strings.HasSuffix(rel, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+func (s *davStore) do(method, rel string, body []byte, header map[string]string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, s.url(rel), r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.SetBasicAuth(s.user, s.pass)
+	return http.DefaultClient.Do(req)
+}
+
+// check turns a response into an error, mapping 404 to fs.ErrNotExist.
+func (s *davStore) check(op, rel string, resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", op, rel, fs.ErrNotExist)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("%s %s: status %d: %s", op, rel, resp.StatusCode, strings.TrimSpace(string(b)))
+}
+
+func (s *davStore) ReadFile(rel string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, rel, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := s.check("get", rel, resp); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *davStore) WriteFile(rel string, data []byte) error {
+	for attempt := 0; ; attempt++ {
+		resp, err := s.do(http.MethodPut, rel, data, nil)
+		if err != nil {
+			return err
+		}
+		// 409 (404 on some servers): a parent collection is missing
+		if (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound) && attempt == 0 {
+			resp.Body.Close()
+			if err := s.mkdirAll(path.Dir(rel)); err != nil {
+				return err
+			}
+			continue
+		}
+		err = s.check("put", rel, resp)
+		resp.Body.Close()
+		return err
+	}
+}
+
+// mkdirAll creates rel and its missing parents, the store root included.
+func (s *davStore) mkdirAll(rel string) error {
+	if rel == "." {
+		rel = ""
+	}
+	if e, err := s.Stat(rel); err == nil && e.IsDir {
+		return nil
+	}
+	if rel != "" {
+		if err := s.mkdirAll(path.Dir(rel)); err != nil {
+			return err
+		}
+	}
+	resp, err := s.do("MKCOL", strings.TrimSuffix(rel, "/")+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil // already exists
+	}
+	return s.check("mkcol", rel, resp)
+}
+
+func (s *davStore) Remove(rel string) error {
+	resp, err := s.do(http.MethodDelete, rel, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s.check("delete", rel, resp)
+}
+
+func (s *davStore) Rename(from, to string) error {
+	if err := s.mkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+	resp, err := s.do("MOVE", from, nil, map[string]string{"Destination": s.url(to), "Overwrite": "F"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return s.check("move", from, resp)
+}
+
+// propfind lists rel (depth 0) or its children too (depth 1).
+func (s *davStore) propfind(rel, depth string) ([]storeEntry, []string, error) {
+	body := []byte(`<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getlastmodified/><d:getcontentlength/></d:prop></d:propfind>`)
+	resp, err := s.do("PROPFIND", rel, body, map[string]string{"Depth": depth, "Content-Type": "text/xml"})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if err := s.check("propfind", rel, resp); err != nil {
+		return nil, nil, err
+	}
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"href"`
+			Prop struct {
+				Collection   *struct{} `xml:"resourcetype>collection"`
+				LastModified string    `xml:"getlastmodified"`
+				Length       int64     `xml:"getcontentlength"`
+			} `xml:"propstat>prop"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, nil, err
+	}
+	entries, hrefs := []storeEntry{}, []string{}
+	for _, r := range ms.Responses {
+		h := r.Href
+		if u, err := url.Parse(h); err == nil {
+			h = u.Path
+		}
+		e := storeEntry{Name: path.Base(strings.TrimRight(h, "/")), IsDir: r.Prop.Collection != nil, Size: r.Prop.Length}
+		e.ModTime, _ = http.ParseTime(r.Prop.LastModified)
+		entries = append(entries, e)
+		hrefs = append(hrefs, strings.TrimRight(h, "/"))
+	}
+	return entries, hrefs, nil
+}
+
+func (s *davStore) ReadDir(rel string) ([]storeEntry, error) {
+	entries, hrefs, err := s.propfind(strings.TrimRight(rel, "/")+"/", "1")
+	if err != nil {
+		return nil, err
+	}
+	self := strings.TrimRight(path.Join(s.base.Path, rel), "/")
+	out := []storeEntry{}
+	for i, e := range entries {
+		if hrefs[i] != self {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *davStore) Stat(rel string) (storeEntry, error) {
+	entries, _, err := s.propfind(rel, "0")
+	if err != nil {
+		return storeEntry{}, err
+	}
+	if len(entries) == 0 {
+		return storeEntry{}, fmt.Errorf("stat %s: %w", rel, fs.ErrNotExist)
+	}
+	return entries[0], nil
+}
+
+// zipStore keeps the whole tree in one zip archive, loaded once and kept in memory;
+// changes are written back (via a temporary file and rename) by Flush, at the end of the
+// command or before a moved card is deleted from the server. Suited to archives of a few MB.
+type zipStore struct {
+	path  string
+	once  sync.Once
+	err   error
+	files map[string]zipFile
+	dirty bool
+}
+
+type zipFile struct {
+	data []byte
+	mod  time.Time
+}
+
+func (s *zipStore) Root() string { return s.path }
+
+func (s *zipStore) load() error {
+	s.once.Do(func() {
+		s.files = map[string]zipFile{}
+		r, err := zip.OpenReader(s.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err != nil {
+			s.err = err
+			return
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				s.err = err
+				return
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				s.err = err
+				return
+			}
+			s.files[f.Name] = zipFile{data: data, mod: f.Modified}
+		}
+	})
+	return s.err
+}
+
+// Flush rewrites the archive if anything changed since it was loaded or last flushed.
+func (s *zipStore) Flush() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *zipStore) flush() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(f)
+	names := []string{}
+	for n := range s.files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		zw, err := w.CreateHeader(&zip.FileHeader{Name: n, Method: zip.Deflate, Modified: s.files[n].mod})
+		if err == nil {
+			_, err = zw.Write(s.files[n].data)
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *zipStore) ReadFile(rel string) ([]byte, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	f, ok := s.files[rel]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+	}
+	return f.data, nil
+}
+
+func (s *zipStore) WriteFile(rel string, data []byte) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.files[rel] = zipFile{data: append([]byte(nil), data...), mod: time.Now()}
+	s.dirty = true
+	return nil
+}
+
+func (s *zipStore) Remove(rel string) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	found := false
+	for n := range s.files {
+		if n == rel || strings.HasPrefix(n, rel+"/") {
+			delete(s.files, n)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+	}
+	s.dirty = true
+	return nil
+}
+
+func (s *zipStore) Rename(from, to string) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	f, ok := s.files[from]
+	if !ok {
+		return fmt.Errorf("%s: %w", from, fs.ErrNotExist)
+	}
+	delete(s.files, from)
+	s.files[to] = f
+	s.dirty = true
+	return nil
+}
+
+func (s *zipStore) ReadDir(rel string) ([]storeEntry, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if rel != "" {
+		prefix = strings.TrimRight(rel, "/") + "/"
+	}
+	seen := map[string]int{}
+	out := []storeEntry{}
+	for n, f := range s.files {
+		rest, ok := strings.CutPrefix(n, prefix)
+		if !ok {
+			continue
+		}
+		name, _, isDir := strings.Cut(rest, "/")
+		if i, ok := seen[name]; ok {
+			if f.mod.After(out[i].ModTime) {
+				out[i].ModTime = f.mod
+			}
+			continue
+		}
+		seen[name] = len(out)
+		e := storeEntry{Name: name, IsDir: isDir, ModTime: f.mod}
+		if !isDir {
+			e.Size = int64(len(f.data))
+		}
+		out = append(out, e)
+	}
+	if len(out) == 0 && rel != "" {
+		return nil, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *zipStore) Stat(rel string) (storeEntry, error) {
+	if err := s.load(); err != nil {
+		return storeEntry{}, err
+	}
+	if f, ok := s.files[rel]; ok {
+		return storeEntry{Name: path.Base(rel), ModTime: f.mod, Size: int64(len(f.data))}, nil
+	}
+	if _, err := s.ReadDir(rel); err == nil {
+		return storeEntry{Name: path.Base(rel), IsDir: true}, nil
+	}
+	return storeEntry{}, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+// companyWords mark a display name as an organisation rather than a person. Only legal
+// suffixes no one is named after are built in; broader words ("bank", "support", "group")
+// belong in triage.company_words, where the user knows their address book.
+var companyWords = []string{
+	"inc", "llc", "llp", "ltd", "limited", "pvt", "plc", "gmbh", "corp", "corporation",
+	"pte", "sarl", "bv",
+}
+
+// triageRoute is the bucket decision for one server card.
+type triageRoute struct {
+	Card   cardData
+	Bucket string
+	Reason string
+}
+
+// triageBucket applies the routing rules in order: no contact method -> neutral,
+// company-only name -> corporate, email but no phone or address -> email_only.
+// It returns "" for cards that stay on the server.
+func triageBucket(card vcard.Card) (string, string) {
+	tel := len(card[vcard.FieldTelephone]) > 0
+	mail := len(card[vcard.FieldEmail]) > 0
+	adr := len(card[vcard.FieldAddress]) > 0
+	switch {
+	case !tel && !mail && !adr:
+		return "neutral", "no phone, email or address"
+	case isCompanyOnly(card):
+		return "corporate", "company name without a person"
+	case mail && !tel && !adr:
+		return "email_only", "email only"
+	}
+	return "", ""
+}
+
+// isCompanyOnly reports whether the card names an organisation rather than a person:
+// KIND:org, FN equal to ORG, or FN containing a company word (rules: triage.company_words).
+func isCompanyOnly(card vcard.Card) bool {
+	if strings.EqualFold(card.Value(vcard.FieldKind), "org") {
+		return true
+	}
+	fn := norm(card.Value(vcard.FieldFormattedName))
+	if fn == "" {
+		return false
+	}
+	if org := norm(strings.Split(card.Value(vcard.FieldOrganization), ";")[0]); org != "" && org == fn {
+		return true
+	}
+	// whole words (or phrases) only. Just legal suffixes are built in; broader words
+	// ("store", "clinic") are the user's call through triage.company_words
+	split := func(s string) string {
+		return strings.Join(strings.FieldsFunc(norm(s), func(r rune) bool {
+			return r == ' ' || r == '.' || r == ',' || r == '(' || r == ')' || r == '/' || r == '&'
+		}), " ")
+	}
+	name := " " + split(fn) + " "
+	for _, w := range append(append([]string{}, companyWords...), rules().Triage.CompanyWords...) {
+		if w = split(w); w != "" && strings.Contains(name, " "+w+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// runTriage routes server cards into buckets per triageBucket and prints a report;
+// with apply the moves go through moveCard like `move`.
+func runTriage(apply bool) {
+	client := newClient()
+	ctx := context.Background()
+	routes := []triageRoute{}
+	for _, cd := range mustFetch(client) {
+		if bucket, reason := triageBucket(cd.Card); bucket != "" {
+			routes = append(routes, triageRoute{Card: cd, Bucket: bucket, Reason: reason})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Bucket != routes[j].Bucket {
+			return routes[i].Bucket < routes[j].Bucket
+		}
+		return norm(routes[i].Card.Card.Value(vcard.FieldFormattedName)) < norm(routes[j].Card.Card.Value(vcard.FieldFormattedName))
+	})
+	bW, nW := len("Bucket"), len("Name")
+	for _, r := range routes {
+		if len(r.Bucket) > bW {
+			bW = len(r.Bucket)
+		}
+		if n := len(r.Card.Card.Value(vcard.FieldFormattedName)); n > nW {
+			nW = n
+		}
+	}
+	fmt.Printf("%-*s  %-*s  %s\n", bW, "Bucket", nW, "Name", "Reason")
+	fmt.Printf("%s  %s  %s\n", strings.Repeat("-", bW), strings.Repeat("-", nW), strings.Repeat("-", len("Reason")))
+	moved := 0
+	for i := range routes {
+		r := &routes[i]
+		fmt.Printf("%-*s  %-*s  %s\n", bW, r.Bucket, nW, r.Card.Card.Value(vcard.FieldFormattedName), r.Reason)
+		if !apply {
+			continue
+		}
+		if _, err := moveCard(ctx, client, &r.Card, r.Bucket, "", "triage: "+r.Reason); err != nil {
+			log.Printf("triage move %s: %v", r.Card.Card.Value(vcard.FieldFormattedName), err)
+			continue
+		}
+		moved++
+	}
+	log.Printf("triage: %d card(s) routed, %d moved. apply=%v", len(routes), moved, apply)
+}
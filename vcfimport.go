@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"mime/quotedprintable"
+	"os"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// decodeVCFText parses every card of a .vcf export. Phones and older accounts still write
+// vCard 2.1, which the decoder does not speak, so each line is first rewritten in 3.0
+// form: quoted-printable values (with "=" soft line breaks) are decoded, CHARSET is
+// applied, bare parameters ("TEL;CELL;PREF:") become TYPE=..., and BASE64 becomes b.
+// Returned cards keep their VERSION, except that 2.1 becomes 3.0.
+func decodeVCFText(data []byte) ([]vcard.Card, error) {
+	text := strings.ReplaceAll(string(bytes.TrimPrefix(data, []byte("\ufeff"))), "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	var out strings.Builder
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		// RFC 6350/2426 folding; quoted-printable lines continue after "=" instead
+		head, _, _ := strings.Cut(line, ":")
+		for !strings.Contains(strings.ToUpper(head), "QUOTED-PRINTABLE") && i+1 < len(lines) && len(lines[i+1]) > 0 && (lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+			i++
+			line += lines[i][1:]
+		}
+		name, params, value, ok := splitVCFLine(line)
+		if !ok {
+			out.WriteString(line + "\n")
+			continue
+		}
+		qp := false
+		charset := ""
+		kept := []string{}
+		for _, p := range params {
+			k, v, hasValue := strings.Cut(p, "=")
+			k = strings.ToUpper(strings.TrimSpace(k))
+			switch {
+			case !hasValue && (k == "QUOTED-PRINTABLE" || k == "BASE64" || k == "8BIT" || k == "7BIT"):
+				v, k = k, "ENCODING"
+				fallthrough
+			case k == "ENCODING":
+				switch strings.ToUpper(v) {
+				case "QUOTED-PRINTABLE":
+					qp = true
+				case "BASE64", "B":
+					kept = append(kept, "ENCODING=b")
+				}
+			case k == "CHARSET":
+				charset = v
+			case !hasValue:
+				kept = append(kept, "TYPE="+strings.ToLower(k))
+			default:
+				kept = append(kept, k+"="+v)
+			}
+		}
+		if qp {
+			// 2.1 soft line breaks: a trailing "=" continues on the next raw line
+			for strings.HasSuffix(value, "=") && i+1 < len(lines) {
+				i++
+				value = value[:len(value)-1] + lines[i]
+			}
+			plain, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+			if err != nil {
+				return nil, fmt.Errorf("%s: quoted-printable: %w", name, err)
+			}
+			value = escapeVCFNewlines(decodeCharset(plain, charset))
+		} else if charset != "" {
+			value = decodeCharset([]byte(value), charset)
+		}
+		if strings.EqualFold(name, vcard.FieldVersion) && strings.TrimSpace(value) == "2.1" {
+			value = "3.0"
+		}
+		out.WriteString(strings.Join(append([]string{name}, kept...), ";") + ":" + value + "\n")
+	}
+
+	cards := []vcard.Card{}
+	dec := vcard.NewDecoder(strings.NewReader(out.String()))
+	for {
+		card, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cards, fmt.Errorf("card %d: %w", len(cards)+1, err)
+		}
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+// splitVCFLine splits "group.NAME;p1;p2=x:value" into name (with group), params and value.
+// A colon inside a quoted parameter value does not end the parameters.
+func splitVCFLine(line string) (name string, params []string, value string, ok bool) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if quoted {
+				continue
+			}
+			head := strings.Split(line[:i], ";")
+			return head[0], head[1:], line[i+1:], head[0] != ""
+		}
+	}
+	return "", nil, "", false
+}
+
+// decodeCharset converts data from the named charset to UTF-8; unknown names pass through.
+func decodeCharset(data []byte, charset string) string {
+	if charset == "" || strings.EqualFold(charset, "utf-8") {
+		return string(data)
+	}
+	enc, err := ianaindex.MIME.Encoding(charset)
+	if err != nil || enc == nil {
+		return string(data)
+	}
+	plain, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(plain)
+}
+
+func escapeVCFNewlines(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// normalizeImportedCard runs an imported card through the same cleanup as add/sync, and
+// gives it a display name when the export only had N, an organization, an email or a phone.
+func normalizeImportedCard(card *vcard.Card) {
+	if strings.TrimSpace(card.Value(vcard.FieldFormattedName)) == "" {
+		n := cardName(*card)
+		fn := strings.Join(strings.Fields(strings.Join([]string{n.Given, n.Additional, n.Family}, " ")), " ")
+		for _, alt := range []string{strings.SplitN(card.Value(vcard.FieldOrganization), ";", 2)[0],
+			strings.ToLower(card.Value(vcard.FieldEmail)), card.Value(vcard.FieldTelephone)} {
+			if fn == "" {
+				fn = strings.TrimSpace(alt)
+			}
+		}
+		if fn != "" {
+			card.SetValue(vcard.FieldFormattedName, fn)
+		}
+	}
+	normalizeDisplayName(card)
+	syncStructuredName(card, true)
+	normalizeEmailsInCard(card)
+	normalizePhonesInCard(card)
+}
+
+// importCardFile implements `import FILE` for vCard, jCard and xCard files; decode is
+// decodeVCFText, decodeJCard or decodeXCard.
+func importCardFile(path string, decode func([]byte) ([]vcard.Card, error), apply bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	cards, err := decode(data)
+	if err != nil {
+		// keep what was read before the broken card; it is reported, not imported
+		log.Printf("[warn] import: %s: %v (%d card(s) read)", path, err, len(cards))
+	}
+	kept := []vcard.Card{}
+	sources := []string{}
+	for i, card := range cards {
+		normalizeImportedCard(&card)
+		if card.Value(vcard.FieldFormattedName) == "" {
+			log.Printf("import: skip card %d: no name, email or phone", i+1)
+			continue
+		}
+		kept = append(kept, card)
+		sources = append(sources, fmt.Sprintf("card %d", i+1))
+	}
+	client := newClient()
+	applyImport("import", client, planImport(mustFetch(client), kept, sources), apply)
+}
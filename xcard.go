@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+const xcardNamespace = "urn:ietf:params:xml:ns:vcard-4.0"
+
+// xmlNode is a generic XML element; xCard is read and written through it so that
+// properties, parameters and components of any name survive.
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+func xmlLeaf(name, text string) xmlNode {
+	return xmlNode{XMLName: xml.Name{Local: name}, Text: text}
+}
+
+// encodeXCard writes cards as an RFC 6351 <vcards> document. Grouped properties
+// ("item1.TEL") go inside <group name="item1">.
+func encodeXCard(cards []vcard.Card) ([]byte, error) {
+	root := xmlNode{XMLName: xml.Name{Space: xcardNamespace, Local: "vcards"}}
+	for _, card := range cards {
+		card = asV4(card)
+		vc := xmlNode{XMLName: xml.Name{Local: "vcard"}}
+		groups := map[string]int{} // group name -> index in vc.Nodes
+		for _, name := range sortedFields(card) {
+			for _, f := range card[name] {
+				prop := xcardProperty(name, f)
+				if f.Group == "" {
+					vc.Nodes = append(vc.Nodes, prop)
+					continue
+				}
+				at, ok := groups[f.Group]
+				if !ok {
+					vc.Nodes = append(vc.Nodes, xmlNode{XMLName: xml.Name{Local: "group"},
+						Attrs: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: f.Group}}})
+					at = len(vc.Nodes) - 1
+					groups[f.Group] = at
+				}
+				vc.Nodes[at].Nodes = append(vc.Nodes[at].Nodes, prop)
+			}
+		}
+		root.Nodes = append(root.Nodes, vc)
+	}
+	data, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+func xcardProperty(name string, f *vcard.Field) xmlNode {
+	typ := propValueType(name, f)
+	prop := xmlNode{XMLName: xml.Name{Local: strings.ToLower(name)}}
+	if params := xcardParams(f.Params); len(params.Nodes) > 0 {
+		prop.Nodes = append(prop.Nodes, params)
+	}
+	switch {
+	case len(cardComponents[name]) > 0 || isStructured(name, f):
+		comps := cardComponents[name]
+		for i, v := range strings.Split(f.Value, ";") {
+			elem := "text"
+			if i < len(comps) {
+				elem = comps[i]
+			}
+			prop.Nodes = append(prop.Nodes, xmlLeaf(elem, v))
+		}
+	case containsString(cardLists, name):
+		for _, v := range strings.Split(f.Value, ",") {
+			prop.Nodes = append(prop.Nodes, xmlLeaf("text", v))
+		}
+	case isDateType(typ):
+		prop.Nodes = append(prop.Nodes, xmlLeaf(typ, extendedValue(f.Value)))
+	default:
+		prop.Nodes = append(prop.Nodes, xmlLeaf(typ, f.Value))
+	}
+	return prop
+}
+
+// xcardParams writes <parameters>; PREF is an integer, everything else text.
+func xcardParams(params vcard.Params) xmlNode {
+	out := xmlNode{XMLName: xml.Name{Local: "parameters"}}
+	for _, k := range sortedParamNames(params) {
+		if k == vcard.ParamValue {
+			continue
+		}
+		elem := "text"
+		if k == vcard.ParamPreferred {
+			elem = "integer"
+		}
+		p := xmlNode{XMLName: xml.Name{Local: strings.ToLower(k)}}
+		for _, v := range params[k] {
+			p.Nodes = append(p.Nodes, xmlLeaf(elem, v))
+		}
+		out.Nodes = append(out.Nodes, p)
+	}
+	return out
+}
+
+func sortedParamNames(params vcard.Params) []string {
+	names := []string{}
+	for k, vs := range params {
+		if len(vs) > 0 {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeXCard reads a <vcards> document (or a lone <vcard>). Cards come back as vCard 4.0.
+func decodeXCard(data []byte) ([]vcard.Card, error) {
+	var root xmlNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("xcard: %w", err)
+	}
+	list := root.Nodes
+	switch root.XMLName.Local {
+	case "vcard":
+		list = []xmlNode{root}
+	case "vcards":
+	default:
+		return nil, fmt.Errorf("xcard: root element is <%s>, want <vcards>", root.XMLName.Local)
+	}
+	cards := []vcard.Card{}
+	for _, vc := range list {
+		if vc.XMLName.Local != "vcard" {
+			continue
+		}
+		card := vcard.Card{}
+		for _, prop := range vc.Nodes {
+			if prop.XMLName.Local != "group" {
+				addXCardProperty(card, "", prop)
+				continue
+			}
+			group := ""
+			for _, a := range prop.Attrs {
+				if a.Name.Local == "name" {
+					group = a.Value
+				}
+			}
+			for _, inner := range prop.Nodes {
+				addXCardProperty(card, group, inner)
+			}
+		}
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+func addXCardProperty(card vcard.Card, group string, prop xmlNode) {
+	name := strings.ToUpper(prop.XMLName.Local)
+	f := &vcard.Field{Group: group, Params: vcard.Params{}}
+	comps := cardComponents[name]
+	parts := map[int][]string{} // component index -> values
+	last := -1
+	typ := ""
+	vals := []string{}
+	for _, n := range prop.Nodes {
+		local := n.XMLName.Local
+		if local == "parameters" {
+			for _, p := range n.Nodes {
+				pv := []string{}
+				for _, v := range p.Nodes {
+					pv = append(pv, v.Text)
+				}
+				if len(p.Nodes) == 0 {
+					pv = append(pv, strings.TrimSpace(p.Text))
+				}
+				f.Params[strings.ToUpper(p.XMLName.Local)] = pv
+			}
+			continue
+		}
+		if i := indexOf(comps, local); i >= 0 {
+			parts[i] = append(parts[i], n.Text)
+			last = max(last, i)
+			continue
+		}
+		typ = local
+		v := n.Text
+		if isDateType(typ) {
+			v = plainValue(v)
+		}
+		vals = append(vals, v)
+	}
+	if last >= 0 {
+		out := make([]string, last+1)
+		for i := range out {
+			out[i] = strings.Join(parts[i], ",")
+		}
+		f.Value = strings.Join(out, ";")
+	} else if name == vcard.FieldOrganization {
+		f.Value = strings.Join(vals, ";")
+	} else {
+		f.Value = strings.Join(vals, ",")
+	}
+	if typ != "" && !sameValueType(name, typ) {
+		f.Params.Set(vcard.ParamValue, typ)
+	}
+	card.Add(name, f)
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
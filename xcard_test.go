@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	vcard "github.com/emersion/go-vcard"
+)
+
+func TestXCardRoundTrip(t *testing.T) {
+	cards := decodeTestCards(t, roundTripCards)
+	data, err := encodeXCard(cards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<vcards xmlns="`+xcardNamespace+`">`) {
+		t.Errorf("missing xCard namespace:\n%s", data)
+	}
+	got, err := decodeXCard(data)
+	if err != nil {
+		t.Fatalf("decode xCard: %v\n%s", err, data)
+	}
+	sameCards(t, cards, got)
+}
+
+func TestXCardRFCExample(t *testing.T) {
+	// RFC 6351 section 4 forms: components, typed parameters and groups.
+	data := `<?xml version="1.0" encoding="UTF-8"?>
+<vcards xmlns="urn:ietf:params:xml:ns:vcard-4.0">
+  <vcard>
+    <fn><text>Simon Perreault</text></fn>
+    <n>
+      <surname>Perreault</surname>
+      <given>Simon</given>
+      <additional/>
+      <prefix/>
+      <suffix>ing. jr</suffix>
+      <suffix>M.Sc.</suffix>
+    </n>
+    <bday><date>--02-03</date></bday>
+    <gender><sex>M</sex></gender>
+    <tel>
+      <parameters>
+        <type><text>work</text><text>voice</text></type>
+        <pref><integer>1</integer></pref>
+      </parameters>
+      <uri>tel:+1-418-656-9254;ext=102</uri>
+    </tel>
+    <group name="contact">
+      <email><text>simon.perreault@viagenie.ca</text></email>
+    </group>
+  </vcard>
+</vcards>`
+	cards, err := decodeXCard([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 {
+		t.Fatalf("got %d cards, want 1", len(cards))
+	}
+	c := cards[0]
+	for field, want := range map[string]string{
+		vcard.FieldName:      "Perreault;Simon;;;ing. jr,M.Sc.",
+		vcard.FieldBirthday:  "--0203",
+		vcard.FieldGender:    "M",
+		vcard.FieldTelephone: "tel:+1-418-656-9254;ext=102",
+	} {
+		if got := c.Value(field); got != want {
+			t.Errorf("%s = %q, want %q", field, got, want)
+		}
+	}
+	tel := c.Get(vcard.FieldTelephone)
+	if tel.Params.Get(vcard.ParamValue) != "uri" || tel.Params.Get(vcard.ParamPreferred) != "1" {
+		t.Errorf("TEL params = %v", tel.Params)
+	}
+	if email := c.Get(vcard.FieldEmail); email == nil || email.Group != "contact" {
+		t.Errorf("EMAIL group = %+v, want contact", email)
+	}
+}
+
+func TestXCardRejectsOtherDocuments(t *testing.T) {
+	if _, err := decodeXCard([]byte(`<contacts><contact/></contacts>`)); err == nil {
+		t.Error("want an error for a non-xCard document")
+	}
+}